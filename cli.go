@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/JamieLeeNZ/url-shortener/handlers"
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
+)
+
+// runImportCommand implements `url-shortener import`, which loads links
+// from a JSON or CSV file into a user's account. It opens only the stores
+// an import writes to and starts none of the server's background work.
+func runImportCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	userID := fs.String("user", "", "ID of the user who will own the imported links")
+	format := fs.String("format", "", "json or csv (default: from the file extension)")
+	preserveKeys := fs.Bool("preserve-keys", false, "keep the keys from the file instead of generating new ones")
+	onConflict := fs.String("on-conflict", "skip", "what to do when a preserved key exists: skip or overwrite")
+	dryRun := fs.Bool("dry-run", false, "report what would happen without writing anything")
+	workspace := fs.String("workspace", "", "ID of a workspace to import into instead of the user's own links")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *userID == "" || fs.NArg() != 1 {
+		return fmt.Errorf("usage: import -user ID [flags] FILE")
+	}
+
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := handlers.ParseImport(f, *format, handlers.MaxImportRecords)
+	if err != nil {
+		return err
+	}
+
+	if *onConflict != models.ImportSkip && *onConflict != models.ImportOverwrite {
+		return fmt.Errorf("-on-conflict must be skip or overwrite")
+	}
+	opts := models.ImportOptions{PreserveKeys: *preserveKeys, OnConflict: *onConflict, DryRun: *dryRun, WorkspaceID: *workspace}
+
+	s, closeStores, err := newImportServer(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	report, err := s.ImportLinks(ctx, *userID, records, opts)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// newImportServer builds a server over the database and, so overwritten
+// keys are invalidated, the Redis cache. In-memory storage is refused since
+// the imported links would be gone when the command exits.
+func newImportServer(ctx context.Context) (*handlers.Server, func(), error) {
+	if os.Getenv("STORAGE_MODE") == "memory" {
+		return nil, nil, fmt.Errorf("import needs a database; STORAGE_MODE=memory does not persist links")
+	}
+
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		return nil, nil, fmt.Errorf("DATABASE_URL is not set")
+	}
+	postgresStore, err := store.NewPostgresStore(dbURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect to database: %w", err)
+	}
+
+	redisStore, redisBreaker := connectRedis(ctx)
+	cachedStore, err := store.NewCachedStore(redisStore, postgresStore, redisBreaker)
+	if err != nil {
+		postgresStore.Close()
+		redisStore.Close()
+		return nil, nil, fmt.Errorf("create cached store: %w", err)
+	}
+
+	keys := newKeyGenerator(newCounterSource(postgresStore, cachedStore.RedisClient(), redisBreaker))
+	s := handlers.NewServer(cachedStore, postgresStore, nil, nil, keys, postgresStore, postgresStore, postgresStore, postgresStore)
+
+	policy, err := handlers.LoadURLPolicy()
+	if err != nil {
+		cachedStore.Close()
+		return nil, nil, fmt.Errorf("load URL policy: %w", err)
+	}
+	s.SetURLPolicy(policy)
+
+	return s, func() { cachedStore.Close() }, nil
+}
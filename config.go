@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+type serverConfig struct {
+	addr              string
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	shutdownTimeout   time.Duration
+	maxHeaderBytes    int
+}
+
+func loadServerConfig() serverConfig {
+	return serverConfig{
+		addr:              envString("LISTEN_ADDR", ":8080"),
+		readTimeout:       envDuration("HTTP_READ_TIMEOUT", 10*time.Second),
+		readHeaderTimeout: envDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		writeTimeout:      envDuration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+		idleTimeout:       envDuration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		shutdownTimeout:   envDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		maxHeaderBytes:    envInt("HTTP_MAX_HEADER_BYTES", 1<<20),
+	}
+}
+
+func envString(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return d
+}
+
+func envInt(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return n
+}
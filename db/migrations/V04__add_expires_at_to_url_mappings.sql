@@ -0,0 +1,22 @@
+-- +migrate Up
+ALTER TABLE url_mappings
+ADD COLUMN expires_at TIMESTAMPTZ;
+
+CREATE INDEX idx_url_mappings_expires_at ON url_mappings (expires_at)
+WHERE expires_at IS NOT NULL;
+
+CREATE TABLE url_mappings_archive (
+  id BIGSERIAL PRIMARY KEY,
+  key TEXT NOT NULL,
+  original_url TEXT NOT NULL,
+  user_id TEXT,
+  created_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  archived_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- +migrate Down
+DROP TABLE url_mappings_archive;
+
+ALTER TABLE url_mappings
+DROP COLUMN expires_at;
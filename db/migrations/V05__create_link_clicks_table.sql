@@ -0,0 +1,14 @@
+-- +migrate Up
+CREATE TABLE link_clicks (
+  id BIGSERIAL PRIMARY KEY,
+  key TEXT NOT NULL,
+  clicked_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  referrer TEXT,
+  user_agent TEXT,
+  ip_hash TEXT
+);
+
+CREATE INDEX idx_link_clicks_key_clicked_at ON link_clicks (key, clicked_at);
+
+-- +migrate Down
+DROP TABLE link_clicks;
@@ -0,0 +1,6 @@
+-- +migrate Up
+-- Each nextval reserves a block of 100 keys, see postgresKeyBlockSize.
+CREATE SEQUENCE url_key_seq MINVALUE 0 START WITH 0 INCREMENT BY 100;
+
+-- +migrate Down
+DROP SEQUENCE url_key_seq;
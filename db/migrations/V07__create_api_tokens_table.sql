@@ -0,0 +1,17 @@
+-- +migrate Up
+CREATE TABLE api_tokens (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  token_hash TEXT UNIQUE NOT NULL,
+  scopes TEXT[] NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_api_tokens_user_id ON api_tokens (user_id);
+
+-- +migrate Down
+DROP TABLE api_tokens;
@@ -0,0 +1,18 @@
+-- +migrate Up
+CREATE TABLE user_identities (
+  provider TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  email TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities (user_id);
+
+-- Users created before identities existed were keyed by their Google ID.
+INSERT INTO user_identities (provider, subject, user_id, email)
+SELECT 'google', id, id, email FROM users;
+
+-- +migrate Down
+DROP TABLE user_identities;
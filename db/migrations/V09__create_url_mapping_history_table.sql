@@ -0,0 +1,14 @@
+-- +migrate Up
+CREATE TABLE url_mapping_history (
+  id BIGSERIAL PRIMARY KEY,
+  key TEXT NOT NULL REFERENCES url_mappings(key) ON DELETE CASCADE,
+  old_original_url TEXT NOT NULL,
+  new_original_url TEXT NOT NULL,
+  changed_by TEXT REFERENCES users(id) ON DELETE SET NULL,
+  changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_url_mapping_history_key ON url_mapping_history (key, id);
+
+-- +migrate Down
+DROP TABLE url_mapping_history;
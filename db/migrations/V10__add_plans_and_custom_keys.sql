@@ -0,0 +1,10 @@
+-- +migrate Up
+ALTER TABLE users ADD COLUMN plan TEXT NOT NULL DEFAULT 'free';
+ALTER TABLE url_mappings ADD COLUMN custom_key BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX idx_url_mappings_user_id ON url_mappings (user_id);
+
+-- +migrate Down
+DROP INDEX idx_url_mappings_user_id;
+ALTER TABLE url_mappings DROP COLUMN custom_key;
+ALTER TABLE users DROP COLUMN plan;
@@ -0,0 +1,12 @@
+-- +migrate Up
+DROP INDEX idx_original_url;
+CREATE UNIQUE INDEX idx_url_mappings_user_original ON url_mappings (user_id, original_url);
+
+-- The new index also serves lookups by user_id alone.
+DROP INDEX idx_url_mappings_user_id;
+
+-- +migrate Down
+-- Fails if two users have since shortened the same URL.
+CREATE INDEX idx_url_mappings_user_id ON url_mappings (user_id);
+DROP INDEX idx_url_mappings_user_original;
+CREATE UNIQUE INDEX idx_original_url ON url_mappings (original_url);
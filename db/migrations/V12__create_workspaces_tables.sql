@@ -0,0 +1,39 @@
+-- +migrate Up
+CREATE TABLE workspaces (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  created_by TEXT REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE workspace_members (
+  workspace_id TEXT NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+  user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+  added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE INDEX idx_workspace_members_user_id ON workspace_members (user_id);
+
+ALTER TABLE url_mappings ADD COLUMN workspace_id TEXT REFERENCES workspaces(id);
+ALTER TABLE url_mappings_archive ADD COLUMN workspace_id TEXT;
+
+-- Personal links are deduplicated per user and workspace links per
+-- workspace.
+DROP INDEX idx_url_mappings_user_original;
+CREATE UNIQUE INDEX idx_url_mappings_user_original ON url_mappings (user_id, original_url)
+WHERE workspace_id IS NULL;
+CREATE UNIQUE INDEX idx_url_mappings_workspace_original ON url_mappings (workspace_id, original_url)
+WHERE workspace_id IS NOT NULL;
+
+-- +migrate Down
+DROP INDEX idx_url_mappings_workspace_original;
+DROP INDEX idx_url_mappings_user_original;
+-- Fails if a user has created the same destination in two places.
+CREATE UNIQUE INDEX idx_url_mappings_user_original ON url_mappings (user_id, original_url);
+
+ALTER TABLE url_mappings_archive DROP COLUMN workspace_id;
+ALTER TABLE url_mappings DROP COLUMN workspace_id;
+DROP TABLE workspace_members;
+DROP TABLE workspaces;
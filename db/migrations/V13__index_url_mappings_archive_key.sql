@@ -0,0 +1,6 @@
+-- +migrate Up
+-- Redirects look up archived keys to answer 410 Gone for expired links.
+CREATE INDEX idx_url_mappings_archive_key ON url_mappings_archive (key, archived_at);
+
+-- +migrate Down
+DROP INDEX idx_url_mappings_archive_key;
@@ -0,0 +1,10 @@
+-- +migrate Up
+-- Link listings page through an owner's links in creation order.
+CREATE INDEX idx_url_mappings_user_created ON url_mappings (user_id, created_at)
+WHERE workspace_id IS NULL;
+CREATE INDEX idx_url_mappings_workspace_created ON url_mappings (workspace_id, created_at)
+WHERE workspace_id IS NOT NULL;
+
+-- +migrate Down
+DROP INDEX idx_url_mappings_workspace_created;
+DROP INDEX idx_url_mappings_user_created;
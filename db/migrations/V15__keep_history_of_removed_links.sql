@@ -0,0 +1,10 @@
+-- +migrate Up
+-- History is an audit trail, so it stays when its link is deleted or
+-- archived.
+ALTER TABLE url_mapping_history DROP CONSTRAINT url_mapping_history_key_fkey;
+
+-- +migrate Down
+DELETE FROM url_mapping_history h
+WHERE NOT EXISTS (SELECT 1 FROM url_mappings m WHERE m.key = h.key);
+ALTER TABLE url_mapping_history
+ADD CONSTRAINT url_mapping_history_key_fkey FOREIGN KEY (key) REFERENCES url_mappings(key) ON DELETE CASCADE;
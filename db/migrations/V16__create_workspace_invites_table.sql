@@ -0,0 +1,15 @@
+-- +migrate Up
+-- Invites for emails nobody has signed in with yet. They become memberships
+-- when someone signs in with a verified email from a trusted provider.
+CREATE TABLE workspace_invites (
+  workspace_id TEXT NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+  email TEXT NOT NULL,
+  role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+  invited_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (workspace_id, email)
+);
+
+CREATE INDEX idx_workspace_invites_email ON workspace_invites (email);
+
+-- +migrate Down
+DROP TABLE workspace_invites;
@@ -0,0 +1,6 @@
+-- +migrate Up
+-- Workspace members are added by email regardless of its case.
+CREATE INDEX idx_users_lower_email ON users (lower(email));
+
+-- +migrate Down
+DROP INDEX idx_users_lower_email;
@@ -5,20 +5,33 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
-var googleOauthConfig *oauth2.Config
+const (
+	oauthGoogleURLAPI = "https://www.googleapis.com/oauth2/v2/userinfo"
+	oauthStateCookie  = "oauthstate"
+	oauthNonceCookie  = "oauthnonce"
+)
 
-const oauthGoogleURLAPI = "https://www.googleapis.com/oauth2/v2/userinfo?access_token="
+// LoginProvider is an external identity provider users can sign in with.
+type LoginProvider interface {
+	Name() string
+	AuthCodeURL(state, nonce string) string
+	Exchange(ctx context.Context, code, nonce string) (models.Identity, error)
+}
 
 type GoogleUser struct {
 	ID            string `json:"id"`
@@ -28,28 +41,145 @@ type GoogleUser struct {
 	Picture       string `json:"picture"`
 }
 
-func InitOAuth() {
+type GoogleProvider struct {
+	config     *oauth2.Config
+	trustEmail bool
+}
+
+var _ LoginProvider = (*GoogleProvider)(nil)
+
+func NewGoogleProvider(clientID, clientSecret, redirectURL string, trustEmail bool) *GoogleProvider {
+	return &GoogleProvider{
+		trustEmail: trustEmail,
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes: []string{
+				"https://www.googleapis.com/auth/userinfo.email",
+				"https://www.googleapis.com/auth/userinfo.profile",
+			},
+			Endpoint: google.Endpoint,
+		},
+	}
+}
+
+func (p *GoogleProvider) Name() string {
+	return "google"
+}
+
+func (p *GoogleProvider) AuthCodeURL(state, nonce string) string {
+	return p.config.AuthCodeURL(state)
+}
+
+func (p *GoogleProvider) Exchange(ctx context.Context, code, nonce string) (models.Identity, error) {
+	token, err := p.config.Exchange(ctx, code)
+	if err != nil {
+		return models.Identity{}, err
+	}
+
+	var gUser GoogleUser
+	client := p.config.Client(ctx, token)
+	resp, err := client.Get(oauthGoogleURLAPI)
+	if err != nil {
+		return models.Identity{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Identity{}, fmt.Errorf("google userinfo: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&gUser); err != nil {
+		return models.Identity{}, err
+	}
+
+	return models.Identity{
+		Provider:      p.Name(),
+		Subject:       gUser.ID,
+		Email:         gUser.Email,
+		EmailVerified: gUser.VerifiedEmail,
+		Name:          gUser.Name,
+		Picture:       gUser.Picture,
+		TrustedEmail:  p.trustEmail,
+	}, nil
+}
+
+// LoadLoginProviders builds the login providers configured in the
+// environment: Google via CLIENT_ID/CLIENT_SECRET/REDIRECT_URL, and any
+// OIDC issuers named in OIDC_PROVIDERS, each configured through
+// OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and _REDIRECT_URL.
+//
+// A provider's verified emails link new identities to existing accounts
+// only if it is trusted: Google unless GOOGLE_TRUST_EMAIL=false, and OIDC
+// issuers with OIDC_<NAME>_TRUST_EMAIL=true. Only trust issuers that
+// control the email domains they vouch for.
+func LoadLoginProviders(ctx context.Context) ([]LoginProvider, error) {
+	var providers []LoginProvider
+
 	clientID := os.Getenv("CLIENT_ID")
 	clientSecret := os.Getenv("CLIENT_SECRET")
 	redirectURL := os.Getenv("REDIRECT_URL")
+	if clientID != "" || clientSecret != "" || redirectURL != "" {
+		if clientID == "" || clientSecret == "" || redirectURL == "" {
+			return nil, errors.New("missing CLIENT_ID or CLIENT_SECRET or REDIRECT_URL environment variables")
+		}
+		trust, err := envBool("GOOGLE_TRUST_EMAIL", true)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, NewGoogleProvider(clientID, clientSecret, redirectURL, trust))
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		issuer := os.Getenv(prefix + "ISSUER")
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		clientSecret := os.Getenv(prefix + "CLIENT_SECRET")
+		redirectURL := os.Getenv(prefix + "REDIRECT_URL")
+		if issuer == "" || clientID == "" || redirectURL == "" {
+			return nil, fmt.Errorf("missing %sISSUER, %sCLIENT_ID or %sREDIRECT_URL", prefix, prefix, prefix)
+		}
 
-	if clientID == "" || clientSecret == "" || redirectURL == "" {
-		log.Fatal("Missing CLIENT_ID or CLIENT_SECRET or REDIRECT_URL environment variables")
+		trust, err := envBool(prefix+"TRUST_EMAIL", false)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := NewOIDCProvider(ctx, strings.ToLower(name), issuer, clientID, clientSecret, redirectURL, trust)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
 	}
 
-	googleOauthConfig = &oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURL:  redirectURL,
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile",
-		},
-		Endpoint: google.Endpoint,
+	if len(providers) == 0 {
+		return nil, errors.New("no login providers configured")
 	}
+	return providers, nil
 }
 
-func generateOauthState(w http.ResponseWriter) string {
+func envBool(name string, fallback bool) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return b, nil
+}
+
+func (s *Server) AddLoginProvider(p LoginProvider) {
+	s.providers[p.Name()] = p
+}
+
+func setRandomCookie(w http.ResponseWriter, name string) string {
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
 		log.Println("failed to generate random state:", err)
@@ -57,60 +187,146 @@ func generateOauthState(w http.ResponseWriter) string {
 		return ""
 	}
 
-	state := base64.URLEncoding.EncodeToString(b)
+	value := base64.URLEncoding.EncodeToString(b)
 	cookie := http.Cookie{
-		Name:     "oauthstate",
-		Value:    state,
+		Name:     name,
+		Value:    value,
 		Expires:  time.Now().Add(20 * time.Minute),
 		HttpOnly: true,
 		Secure:   false, // Change to true in production with HTTPS
 	}
 	http.SetCookie(w, &cookie)
 
-	return state
+	return value
+}
+
+func clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Expires:  time.Now().Add(-1 * time.Hour),
+		HttpOnly: true,
+	})
+}
+
+// Login sends the user straight to the only configured provider, or lists
+// the available providers when there is more than one.
+func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
+	if len(s.providers) == 1 {
+		for name := range s.providers {
+			http.Redirect(w, r, "/login/"+name, http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	type providerLink struct {
+		Name     string `json:"name"`
+		LoginURL string `json:"login_url"`
+	}
+	links := []providerLink{}
+	for name := range s.providers {
+		links = append(links, providerLink{Name: name, LoginURL: "/login/" + name})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]providerLink{"providers": links})
 }
 
-func (s *Server) GoogleLogin(w http.ResponseWriter, r *http.Request) {
-	state := generateOauthState(w)
+func (s *Server) ProviderLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.providers[r.PathValue("provider")]
+	if !ok {
+		http.Error(w, "unknown login provider", http.StatusNotFound)
+		return
+	}
+
+	state := setRandomCookie(w, oauthStateCookie)
 	if state == "" {
 		return
 	}
-	url := googleOauthConfig.AuthCodeURL(state)
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	nonce := setRandomCookie(w, oauthNonceCookie)
+	if nonce == "" {
+		return
+	}
+
+	http.Redirect(w, r, provider.AuthCodeURL(state, nonce), http.StatusTemporaryRedirect)
 }
 
-func (s *Server) GoogleCallback(w http.ResponseWriter, r *http.Request) {
-	oauthState, err := r.Cookie("oauthstate")
+// ProviderCallback completes a login. If the user already has a session the
+// new identity is linked to their account instead of starting a new one.
+func (s *Server) ProviderCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.providers[r.PathValue("provider")]
+	if !ok {
+		http.Error(w, "unknown login provider", http.StatusNotFound)
+		return
+	}
+
+	oauthState, err := r.Cookie(oauthStateCookie)
 	if err != nil {
 		http.Error(w, "State cookie missing", http.StatusBadRequest)
 		return
 	}
 
 	if r.FormValue("state") != oauthState.Value {
-		log.Println("invalid oauth google state")
+		log.Println("invalid oauth state for provider:", provider.Name())
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	data, err := getUserDataFromGoogle(r.FormValue("code"))
+	var nonce string
+	if c, err := r.Cookie(oauthNonceCookie); err == nil {
+		nonce = c.Value
+	}
+	clearCookie(w, oauthStateCookie)
+	clearCookie(w, oauthNonceCookie)
+
+	identity, err := provider.Exchange(r.Context(), r.FormValue("code"), nonce)
 	if err != nil {
-		log.Println("getUserDataFromGoogle error:", err)
+		log.Printf("%s login error: %v", provider.Name(), err)
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	var gUser GoogleUser
-	if err := json.Unmarshal(data, &gUser); err != nil {
-		log.Println("json unmarshal error:", err)
-		http.Error(w, "Failed to parse user data", http.StatusInternalServerError)
+	if current, err := s.getSessionUser(r); err == nil {
+		if err := s.userStore.LinkIdentity(r.Context(), current.ID, identity); errors.Is(err, store.ErrConflict) {
+			http.Error(w, "this account is already linked to another user", http.StatusConflict)
+			return
+		} else if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(current)
 		return
 	}
 
+	savedUser, err := s.userStore.GetOrCreateUserByIdentity(r.Context(), identity)
+	if errors.Is(err, store.ErrEmailInUse) {
+		http.Error(w, "an account with this email already exists; sign in with the provider you used before, then sign in here again to link it", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
+	// Only an email the provider vouches for may claim workspace invites.
+	if identity.EmailVerified && identity.TrustedEmail {
+		s.acceptInvites(r.Context(), savedUser.ID, identity.Email)
+	}
+
+	if err := s.createSession(w, r.Context(), savedUser); err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(savedUser)
+}
+
+func (s *Server) DevLogin(w http.ResponseWriter, r *http.Request) {
 	user := models.User{
-		ID:      gUser.ID,
-		Email:   gUser.Email,
-		Name:    gUser.Name,
-		Picture: gUser.Picture,
+		ID:    "dev-user",
+		Email: "dev@localhost",
+		Name:  "Local Developer",
 	}
 
 	savedUser, err := s.userStore.GetOrCreateUser(r.Context(), user)
@@ -118,6 +334,7 @@ func (s *Server) GoogleCallback(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to save user", http.StatusInternalServerError)
 		return
 	}
+	s.acceptInvites(r.Context(), savedUser.ID, savedUser.Email)
 
 	if err := s.createSession(w, r.Context(), savedUser); err != nil {
 		http.Error(w, "Failed to create session", http.StatusInternalServerError)
@@ -128,17 +345,14 @@ func (s *Server) GoogleCallback(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(savedUser)
 }
 
-func getUserDataFromGoogle(code string) ([]byte, error) {
-	token, err := googleOauthConfig.Exchange(context.Background(), code)
-	if err != nil {
-		return nil, err
+// acceptInvites adds userID to the workspaces email was invited to. A
+// failure is logged rather than failing the sign-in; the invites stay
+// pending for the next one.
+func (s *Server) acceptInvites(ctx context.Context, userID, email string) {
+	if email == "" {
+		return
 	}
-
-	resp, err := http.Get(oauthGoogleURLAPI + token.AccessToken)
-	if err != nil {
-		return nil, err
+	if err := s.workspaces.AcceptInvites(ctx, userID, email); err != nil {
+		log.Printf("failed to accept workspace invites for %s: %v", userID, err)
 	}
-	defer resp.Body.Close()
-
-	return io.ReadAll(resp.Body)
 }
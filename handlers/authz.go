@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
+)
+
+var (
+	errForbidden = errors.New("forbidden")
+	errNotOwner  = fmt.Errorf("%w: you do not own this URL", errForbidden)
+	errNotMember = fmt.Errorf("%w: you are not a member of this workspace", errForbidden)
+)
+
+// authorizeLink loads the link at key and checks that user may act on it
+// with at least role. Personal links are open only to the user who created
+// them; workspace links to members holding the role. Handlers that read or
+// change a single link's details go through here rather than comparing
+// owners themselves.
+func (s *Server) authorizeLink(ctx context.Context, user *models.User, key, role string) (models.URLMapping, error) {
+	mapping, err := s.urlStore.Get(ctx, key)
+	if err != nil {
+		return models.URLMapping{}, err
+	}
+
+	if mapping.WorkspaceID == "" {
+		if mapping.UserID != user.ID {
+			return models.URLMapping{}, errNotOwner
+		}
+		return mapping, nil
+	}
+	if err := s.requireRole(ctx, user, mapping.WorkspaceID, role); err != nil {
+		return models.URLMapping{}, err
+	}
+	return mapping, nil
+}
+
+// requireRole checks that user holds at least role in the workspace.
+func (s *Server) requireRole(ctx context.Context, user *models.User, workspaceID, role string) error {
+	have, err := s.workspaces.GetMemberRole(ctx, workspaceID, user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return errNotMember
+	} else if err != nil {
+		return err
+	}
+	if !models.RoleAtLeast(have, role) {
+		return fmt.Errorf("%w: this requires the %s role", errForbidden, role)
+	}
+	return nil
+}
+
+// requestOwner returns who the links r creates or lists belong to: the
+// workspace named by the workspace query parameter or X-Workspace-ID header,
+// in which user must hold at least role, or otherwise user personally.
+func (s *Server) requestOwner(r *http.Request, user *models.User, role string) (models.LinkOwner, error) {
+	id := r.URL.Query().Get("workspace")
+	if id == "" {
+		id = r.Header.Get("X-Workspace-ID")
+	}
+	if id == "" {
+		return models.LinkOwner{UserID: user.ID}, nil
+	}
+
+	if err := s.requireRole(r.Context(), user, id, role); err != nil {
+		return models.LinkOwner{}, err
+	}
+	return models.LinkOwner{WorkspaceID: id}, nil
+}
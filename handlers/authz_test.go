@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
+)
+
+func newTestServer(t *testing.T) (*Server, *store.MemoryStore) {
+	t.Helper()
+
+	m := store.NewMemoryStore()
+	return NewServer(m, m, store.NewMemorySessionStore(), m, store.NewRandomKeyGenerator(6), m, m, m, m), m
+}
+
+func newAuthzTestServer(t *testing.T) (http.Handler, *store.MemoryStore) {
+	t.Helper()
+
+	s, m := newTestServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /{key}", s.UpdateHandler)
+	mux.HandleFunc("DELETE /{key}", s.DeleteHandler)
+	mux.HandleFunc("GET /links/{key}/stats", s.LinkStatsHandler)
+	mux.HandleFunc("GET /links/{key}/history", s.LinkHistoryHandler)
+	mux.HandleFunc("POST /links/{key}/rollback", s.RollbackHandler)
+	return mux, m
+}
+
+func createTestUser(t *testing.T, m *store.MemoryStore, id string) *models.User {
+	t.Helper()
+
+	user, err := m.GetOrCreateUser(context.Background(), models.User{ID: id, Email: id + "@example.com"})
+	if err != nil {
+		t.Fatalf("create user %s: %v", id, err)
+	}
+	return &user
+}
+
+func createTestLink(t *testing.T, m *store.MemoryStore, mapping models.URLMapping) {
+	t.Helper()
+
+	if err := m.Set(context.Background(), mapping); err != nil {
+		t.Fatalf("create link %s: %v", mapping.Key, err)
+	}
+}
+
+func serveAs(h http.Handler, user *models.User, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestOtherUsersCannotTouchPersonalLinks(t *testing.T) {
+	h, m := newAuthzTestServer(t)
+	ctx := context.Background()
+
+	alice := createTestUser(t, m, "alice")
+	bob := createTestUser(t, m, "bob")
+	createTestLink(t, m, models.URLMapping{Key: "alice-link", Original: "https://example.com/a", UserID: alice.ID})
+	if err := m.Update(ctx, "alice-link", "https://example.com/b", nil, false, alice.ID); err != nil {
+		t.Fatalf("update link: %v", err)
+	}
+
+	tests := []struct {
+		name, method, target, body string
+	}{
+		{"update", http.MethodPut, "/alice-link", `{"original_url": "https://example.com/bob"}`},
+		{"delete", http.MethodDelete, "/alice-link", ""},
+		{"stats", http.MethodGet, "/links/alice-link/stats", ""},
+		{"history", http.MethodGet, "/links/alice-link/history", ""},
+		{"rollback", http.MethodPost, "/links/alice-link/rollback", `{"revision_id": 1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(h, bob, tt.method, tt.target, tt.body)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s %s as another user: got %d, want %d", tt.method, tt.target, w.Code, http.StatusForbidden)
+			}
+		})
+	}
+
+	mapping, err := m.Get(ctx, "alice-link")
+	if err != nil {
+		t.Fatalf("link is gone after forbidden requests: %v", err)
+	}
+	if mapping.Original != "https://example.com/b" {
+		t.Errorf("link changed by forbidden requests: got %q", mapping.Original)
+	}
+}
+
+func TestWorkspaceRolesGateLinkChanges(t *testing.T) {
+	h, m := newAuthzTestServer(t)
+	ctx := context.Background()
+
+	owner := createTestUser(t, m, "owner")
+	viewer := createTestUser(t, m, "viewer")
+	editor := createTestUser(t, m, "editor")
+
+	ws, err := m.CreateWorkspace(ctx, "team", owner.ID)
+	if err != nil {
+		t.Fatalf("create workspace: %v", err)
+	}
+	for _, member := range []struct {
+		user *models.User
+		role string
+	}{
+		{viewer, models.RoleViewer},
+		{editor, models.RoleEditor},
+	} {
+		if _, err := m.AddMember(ctx, ws.ID, member.user.Email, member.role); err != nil {
+			t.Fatalf("add %s: %v", member.role, err)
+		}
+	}
+	createTestLink(t, m, models.URLMapping{Key: "team-link", Original: "https://example.com/team", UserID: owner.ID, WorkspaceID: ws.ID})
+
+	update := `{"original_url": "https://example.com/new"}`
+
+	if w := serveAs(h, viewer, http.MethodPut, "/team-link", update); w.Code != http.StatusForbidden {
+		t.Errorf("viewer update: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := serveAs(h, viewer, http.MethodDelete, "/team-link", ""); w.Code != http.StatusForbidden {
+		t.Errorf("viewer delete: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	if w := serveAs(h, editor, http.MethodPut, "/team-link", update); w.Code != http.StatusNoContent {
+		t.Errorf("editor update: got %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	if mapping, err := m.Get(ctx, "team-link"); err != nil || mapping.Original != "https://example.com/new" {
+		t.Errorf("editor update not applied: %+v, %v", mapping, err)
+	}
+	if w := serveAs(h, editor, http.MethodDelete, "/team-link", ""); w.Code != http.StatusNoContent {
+		t.Errorf("editor delete: got %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
+)
+
+const (
+	maxBulkItems     = 10000
+	maxBulkBodyBytes = 16 << 20
+	bulkBatchSize    = 500
+)
+
+// BulkCreateHandler shortens many URLs in one request. The body is either a
+// JSON array or a stream of newline-delimited JSON objects, each shaped like
+// a single create request. Every item gets its own result; one bad item
+// does not fail the others.
+func (s *Server) BulkCreateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	reqs, err := decodeBulkRequests(http.MaxBytesReader(w, r.Body, maxBulkBodyBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	owner, err := s.requestOwner(r, user, models.RoleEditor)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	budget, err := s.linkBudget(ctx, owner)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	originals := make([]string, len(reqs))
+	for i, req := range reqs {
+		originals[i] = req.Original
+	}
+	ctx = s.policy.Prefetch(ctx, originals)
+
+	results := make([]models.BulkShortenResult, len(reqs))
+	var valid []int
+	for i, req := range reqs {
+		results[i] = models.BulkShortenResult{Index: i, Original: req.Original}
+
+		err := s.validateShortenRequest(ctx, req)
+		if err == nil && req.CustomKey != "" {
+			err = validateCustomKey(req.CustomKey)
+		}
+		if err == nil {
+			// Items whose URL is already shortened take from the budget
+			// too, so a bulk request near the limit may stop a little early.
+			err = budget.Take(req.CustomKey != "")
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		valid = append(valid, i)
+	}
+
+	for start := 0; start < len(valid); start += bulkBatchSize {
+		batch := valid[start:min(start+bulkBatchSize, len(valid))]
+		if err := s.createBatch(ctx, user.ID, owner, reqs, batch, results); err != nil {
+			// Earlier batches are already committed, so report the rest as
+			// failed rather than failing the whole request.
+			log.Println("bulk create failed:", err)
+			for _, i := range valid[start:] {
+				if results[i].Key == "" && results[i].Error == "" {
+					results[i].Error = "service temporarily unavailable"
+				}
+			}
+			break
+		}
+	}
+
+	resp := models.BulkShortenResponse{Results: results}
+	for _, result := range results {
+		if result.Error == "" {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// createBatch inserts the requests at indexes idx for owner with one
+// SetBatch call, retrying generated keys that collide, and fills in their
+// results.
+func (s *Server) createBatch(ctx context.Context, userID string, owner models.LinkOwner, reqs []models.URLShortenRequest, idx []int, results []models.BulkShortenResult) error {
+	mappings := make([]models.URLMapping, len(idx))
+	for j, i := range idx {
+		mappings[j] = models.URLMapping{
+			Key:         reqs[i].CustomKey,
+			Original:    reqs[i].Original,
+			UserID:      userID,
+			ExpiresAt:   reqs[i].ExpiresAt,
+			Custom:      reqs[i].CustomKey != "",
+			WorkspaceID: owner.WorkspaceID,
+		}
+	}
+
+	remaining := make([]int, len(idx))
+	for j := range idx {
+		remaining[j] = j
+	}
+
+	for attempt := 0; len(remaining) > 0; attempt++ {
+		batch := make([]models.URLMapping, len(remaining))
+		for n, j := range remaining {
+			if reqs[idx[j]].CustomKey == "" {
+				key, err := s.keys.NextKey(ctx)
+				if err != nil {
+					return err
+				}
+				mappings[j].Key = key
+			}
+			batch[n] = mappings[j]
+		}
+
+		errs, err := s.urlStore.SetBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+
+		var retry []int
+		for n, j := range remaining {
+			i := idx[j]
+			custom := reqs[i].CustomKey
+
+			switch {
+			case errs[n] == nil:
+				results[i].Key = mappings[j].Key
+			case errors.Is(errs[n], store.ErrKeyTaken) && custom == "" && attempt+1 < maxKeyAttempts:
+				retry = append(retry, j)
+			case errors.Is(errs[n], store.ErrKeyTaken) && custom != "":
+				results[i].Error = "custom key is already in use"
+			case errors.Is(errs[n], store.ErrKeyTaken):
+				results[i].Error = fmt.Sprintf("no free key found after %d attempts", maxKeyAttempts)
+			case errors.Is(errs[n], store.ErrConflict):
+				// Like single creates, an already shortened URL returns its
+				// existing key.
+				existing, err := s.urlStore.GetByOriginal(ctx, owner, reqs[i].Original)
+				if errors.Is(err, store.ErrNotFound) {
+					results[i].Error = errs[n].Error()
+				} else if err != nil {
+					return err
+				} else if custom != "" && custom != existing.Key {
+					results[i].Error = fmt.Sprintf("original URL is already shortened as %q", existing.Key)
+				} else {
+					results[i].Key = existing.Key
+				}
+			default:
+				results[i].Error = errs[n].Error()
+			}
+		}
+		remaining = retry
+	}
+	return nil
+}
+
+// decodeBulkRequests reads either a JSON array of requests or a stream of
+// newline-delimited JSON requests.
+func decodeBulkRequests(body io.Reader) ([]models.URLShortenRequest, error) {
+	br := bufio.NewReader(body)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, fmt.Errorf("request body is empty")
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid JSON")
+	}
+
+	var reqs []models.URLShortenRequest
+	dec := json.NewDecoder(br)
+	if first == '[' {
+		if err := dec.Decode(&reqs); err != nil {
+			return nil, fmt.Errorf("invalid JSON")
+		}
+	} else {
+		for {
+			var req models.URLShortenRequest
+			err := dec.Decode(&req)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("invalid JSON in item %d", len(reqs))
+			}
+			reqs = append(reqs, req)
+			if len(reqs) > maxBulkItems {
+				break
+			}
+		}
+	}
+
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("no items to shorten")
+	}
+	if len(reqs) > maxBulkItems {
+		return nil, fmt.Errorf("at most %d items may be shortened per request", maxBulkItems)
+	}
+	return reqs, nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, br.UnreadByte()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// readinessCheck pings one dependency. When a required dependency is down
+// the instance reports itself as not ready.
+type readinessCheck struct {
+	name     string
+	required bool
+	ping     func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status    string  `json:"status"`
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func (s *Server) AddReadinessCheck(name string, required bool, ping func(ctx context.Context) error) {
+	s.checks = append(s.checks, readinessCheck{name: name, required: required, ping: ping})
+}
+
+func (s *Server) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]string{"status": "OK"}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	results := make(map[string]checkResult, len(s.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, check := range s.checks {
+		wg.Add(1)
+		go func(check readinessCheck) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.ping(ctx)
+			result := checkResult{
+				Status:    "up",
+				Required:  check.required,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "down"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[check.name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	status, code := "ready", http.StatusOK
+	for _, result := range results {
+		if result.Required && result.Status != "up" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": status,
+		"checks": results,
+	})
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
+)
+
+func (s *Server) LinkHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	key := r.PathValue("key")
+
+	_, err := s.authorizeLink(ctx, user, key, models.RoleViewer)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	revisions, err := s.history.ListLinkHistory(ctx, key)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// RollbackHandler restores the target a link had before the given
+// revision. The rollback is itself recorded as a new revision.
+func (s *Server) RollbackHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	key := r.PathValue("key")
+
+	var req models.RollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RevisionID <= 0 {
+		http.Error(w, "revision_id is required", http.StatusBadRequest)
+		return
+	}
+
+	_, err := s.authorizeLink(ctx, user, key, models.RoleEditor)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	rev, err := s.history.GetLinkRevision(ctx, key, req.RevisionID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// The old target must still pass today's policy.
+	if err := s.policy.Check(ctx, rev.OldOriginal); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Going through urlStore keeps the cache tiers in step with the database.
+	if err := s.urlStore.Update(ctx, key, rev.OldOriginal, nil, false, user.ID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
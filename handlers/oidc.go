@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcHTTPTimeout  = 10 * time.Second
+	idTokenClockSkew = time.Minute
+)
+
+var oidcHTTPClient = &http.Client{Timeout: oidcHTTPTimeout}
+
+// OIDCProvider logs users in against any OpenID Connect issuer, configured
+// through the issuer's discovery document and verified with its JWKS.
+type OIDCProvider struct {
+	name       string
+	config     *oauth2.Config
+	verifier   *oidc.IDTokenVerifier
+	trustEmail bool
+}
+
+var _ LoginProvider = (*OIDCProvider)(nil)
+
+func NewOIDCProvider(ctx context.Context, name, issuer, clientID, clientSecret, redirectURL string, trustEmail bool) (*OIDCProvider, error) {
+	// The provider keeps using this client to refresh the issuer's keys.
+	ctx = oidc.ClientContext(ctx, oidcHTTPClient)
+
+	provider, err := oidc.NewProvider(ctx, strings.TrimSuffix(issuer, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery for %s: %w", name, err)
+	}
+
+	return &OIDCProvider{
+		name: name,
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+			Endpoint:     provider.Endpoint(),
+		},
+		verifier:   provider.Verifier(&oidc.Config{ClientID: clientID}),
+		trustEmail: trustEmail,
+	}, nil
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+func (p *OIDCProvider) AuthCodeURL(state, nonce string) string {
+	return p.config.AuthCodeURL(state, oidc.Nonce(nonce))
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce string) (models.Identity, error) {
+	token, err := p.config.Exchange(ctx, code)
+	if err != nil {
+		return models.Identity{}, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return models.Identity{}, errors.New("token response has no id_token")
+	}
+
+	// Verify checks the signature, issuer, audience, exp and nbf.
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return models.Identity{}, err
+	}
+	switch {
+	case idToken.Nonce != nonce:
+		return models.Identity{}, errors.New("id_token nonce mismatch")
+	case idToken.IssuedAt.After(time.Now().Add(idTokenClockSkew)):
+		return models.Identity{}, errors.New("id_token was issued in the future")
+	case idToken.Subject == "":
+		return models.Identity{}, errors.New("id_token has no subject")
+	}
+
+	var claims struct {
+		Email         string       `json:"email"`
+		EmailVerified flexibleBool `json:"email_verified"`
+		Name          string       `json:"name"`
+		Picture       string       `json:"picture"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return models.Identity{}, fmt.Errorf("id_token claims: %w", err)
+	}
+
+	return models.Identity{
+		Provider:      p.name,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+		Picture:       claims.Picture,
+		TrustedEmail:  p.trustEmail,
+	}, nil
+}
+
+// flexibleBool accepts true/false as booleans or strings, since some
+// providers send email_verified as "true".
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean: %s", data)
+	}
+	return nil
+}
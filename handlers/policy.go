@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxURLLength = 2048
+	resolveTimeout      = 2 * time.Second
+
+	// Bounds for resolving the hosts of a bulk request or import. Hosts
+	// not resolved within prefetchTimeout are treated as unresolvable.
+	maxConcurrentLookups = 16
+	prefetchTimeout      = 10 * time.Second
+)
+
+var errURLNotAllowed = errors.New("destination not allowed")
+
+// sharedAddressSpace is the carrier-grade NAT range from RFC 6598, which
+// net.IP does not count as private.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// URLPolicy decides which destinations may be shortened. The block and
+// allow lists come from a file that is re-read when it changes.
+type URLPolicy struct {
+	schemes      map[string]bool
+	maxLength    int
+	blockPrivate bool
+	resolveHosts bool
+	ownHosts     map[string]bool
+	path         string
+
+	mu      sync.RWMutex
+	block   []string
+	allow   []string
+	modTime time.Time
+}
+
+// NewURLPolicy returns the default policy: http and https only, at most
+// defaultMaxURLLength characters, no private or loopback addresses.
+func NewURLPolicy() *URLPolicy {
+	return &URLPolicy{
+		schemes:      map[string]bool{"http": true, "https": true},
+		maxLength:    defaultMaxURLLength,
+		blockPrivate: true,
+		ownHosts:     make(map[string]bool),
+	}
+}
+
+// LoadURLPolicy builds the policy from the environment:
+//
+//	URL_ALLOWED_SCHEMES     comma-separated, default "http,https"
+//	URL_MAX_LENGTH          default 2048
+//	URL_ALLOW_PRIVATE_IPS   "true" to permit private and loopback hosts
+//	URL_RESOLVE_HOSTS       "true" to also check the addresses a host resolves to
+//	URL_OWN_DOMAINS         hosts that redirect through us, in addition to BASE_URL
+//	URL_POLICY_FILE         block/allow list file, see loadLists
+func LoadURLPolicy() (*URLPolicy, error) {
+	p := NewURLPolicy()
+
+	if v := os.Getenv("URL_ALLOWED_SCHEMES"); v != "" {
+		p.schemes = make(map[string]bool)
+		for _, scheme := range strings.Split(v, ",") {
+			if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+				p.schemes[scheme] = true
+			}
+		}
+	}
+	if v := os.Getenv("URL_MAX_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid URL_MAX_LENGTH: %q", v)
+		}
+		p.maxLength = n
+	}
+	p.blockPrivate = os.Getenv("URL_ALLOW_PRIVATE_IPS") != "true"
+	p.resolveHosts = os.Getenv("URL_RESOLVE_HOSTS") == "true"
+
+	own := strings.Split(os.Getenv("URL_OWN_DOMAINS"), ",")
+	if base, err := url.Parse(os.Getenv("BASE_URL")); err == nil {
+		own = append(own, base.Hostname())
+	}
+	for _, host := range own {
+		if host = normalizeHost(host); host != "" {
+			p.ownHosts[host] = true
+		}
+	}
+
+	if p.path = os.Getenv("URL_POLICY_FILE"); p.path != "" {
+		if err := p.reload(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (s *Server) SetURLPolicy(p *URLPolicy) {
+	s.policy = p
+}
+
+// loadLists reads the block/allow list file. Each line is "block DOMAIN" or
+// "allow DOMAIN"; a domain also covers its subdomains. Blank lines and lines
+// starting with # are ignored. When any allow entries exist, only those
+// domains may be shortened.
+func (p *URLPolicy) loadLists() (block, allow []string, err error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("%s:%d: expected \"block DOMAIN\" or \"allow DOMAIN\"", p.path, line)
+		}
+		domain := normalizeHost(fields[1])
+		switch strings.ToLower(fields[0]) {
+		case "block":
+			block = append(block, domain)
+		case "allow":
+			allow = append(allow, domain)
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: unknown rule %q", p.path, line, fields[0])
+		}
+	}
+	return block, allow, scanner.Err()
+}
+
+// reload re-reads the list file if it changed since the last load.
+func (p *URLPolicy) reload() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+
+	p.mu.RLock()
+	unchanged := info.ModTime().Equal(p.modTime)
+	p.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	block, allow, err := p.loadLists()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.block, p.allow, p.modTime = block, allow, info.ModTime()
+	p.mu.Unlock()
+
+	log.Printf("[policy] loaded %d blocked and %d allowed domains from %s", len(block), len(allow), p.path)
+	return nil
+}
+
+// StartReload checks the list file for changes every interval until ctx is
+// cancelled. A file that fails to load leaves the previous lists in place.
+func (p *URLPolicy) StartReload(ctx context.Context, interval time.Duration) {
+	if p.path == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.reload(); err != nil {
+					log.Printf("[policy] failed to reload %s: %v", p.path, err)
+				}
+			}
+		}
+	}()
+}
+
+// Check returns an error wrapping errURLNotAllowed if raw may not be
+// shortened.
+func (p *URLPolicy) Check(ctx context.Context, raw string) error {
+	if len(raw) > p.maxLength {
+		return fmt.Errorf("%w: URL is longer than %d characters", errURLNotAllowed, p.maxLength)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL format")
+	}
+	if !p.schemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("%w: scheme %q is not allowed", errURLNotAllowed, u.Scheme)
+	}
+
+	host := normalizeHost(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: URL has no host", errURLNotAllowed)
+	}
+	if p.ownHosts[host] {
+		return fmt.Errorf("%w: links to this service would redirect in a loop", errURLNotAllowed)
+	}
+
+	p.mu.RLock()
+	blocked := matchDomain(host, p.block)
+	allowed := len(p.allow) == 0 || matchDomain(host, p.allow)
+	p.mu.RUnlock()
+	if blocked || !allowed {
+		return fmt.Errorf("%w: host %q is not allowed", errURLNotAllowed, host)
+	}
+
+	if p.blockPrivate {
+		if err := p.checkAddress(ctx, host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAddress refuses hosts that are, or with URL_RESOLVE_HOSTS resolve
+// to, internal addresses. It only sees DNS at the time the link is created:
+// a host may resolve differently when the link is followed (DNS rebinding),
+// and nothing here protects against that.
+func (p *URLPolicy) checkAddress(ctx context.Context, host string) error {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: local addresses are not allowed", errURLNotAllowed)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if isInternalIP(ip) {
+			return fmt.Errorf("%w: private and loopback addresses are not allowed", errURLNotAllowed)
+		}
+		return nil
+	}
+
+	// Browsers accept shorthand IPv4 forms such as "2130706433" or
+	// "0x7f.1". No real top-level domain is numeric, so refuse them.
+	labels := strings.Split(host, ".")
+	if last := labels[len(labels)-1]; last != "" && strings.Trim(strings.ToLower(last), "0123456789abcdefx") == "" && strings.ContainsAny(last, "0123456789") {
+		return fmt.Errorf("%w: numeric host %q is not allowed", errURLNotAllowed, host)
+	}
+
+	if !p.resolveHosts {
+		return nil
+	}
+	var addrs []net.IPAddr
+	var err error
+	lookups, _ := ctx.Value(hostLookupsKey{}).(hostLookups)
+	if lookup, ok := lookups[host]; ok {
+		addrs, err = lookup.addrs, lookup.err
+	} else {
+		addrs, err = lookupHost(ctx, host)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: host %q could not be resolved", errURLNotAllowed, host)
+	}
+	for _, addr := range addrs {
+		if isInternalIP(addr.IP) {
+			return fmt.Errorf("%w: host %q resolves to a private address", errURLNotAllowed, host)
+		}
+	}
+	return nil
+}
+
+type hostLookup struct {
+	addrs []net.IPAddr
+	err   error
+}
+
+type hostLookups map[string]hostLookup
+
+type hostLookupsKey struct{}
+
+// Prefetch resolves the unique hosts of raws up front, several at a time,
+// and returns a context under which Check uses those results instead of
+// resolving hosts one URL after another.
+func (p *URLPolicy) Prefetch(ctx context.Context, raws []string) context.Context {
+	if !p.blockPrivate || !p.resolveHosts {
+		return ctx
+	}
+
+	hosts := make(map[string]bool)
+	for _, raw := range raws {
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		if host := normalizeHost(u.Hostname()); host != "" && net.ParseIP(host) == nil {
+			hosts[host] = true
+		}
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, prefetchTimeout)
+	defer cancel()
+
+	lookups := make(hostLookups, len(hosts))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentLookups)
+	for host := range hosts {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			addrs, err := lookupHost(lookupCtx, host)
+			mu.Lock()
+			lookups[host] = hostLookup{addrs: addrs, err: err}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return context.WithValue(ctx, hostLookupsKey{}, lookups)
+}
+
+func lookupHost(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	return net.DefaultResolver.LookupIPAddr(ctx, host)
+}
+
+func isInternalIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast() || sharedAddressSpace.Contains(ip)
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+func matchDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
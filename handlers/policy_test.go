@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestURLPolicyCheck(t *testing.T) {
+	p := NewURLPolicy()
+	p.ownHosts["sho.rt"] = true
+	p.block = []string{"evil.com"}
+
+	tests := []struct {
+		name    string
+		url     string
+		allowed bool
+	}{
+		{"https", "https://example.com/path", true},
+		{"http", "http://example.com", true},
+		{"scheme", "ftp://example.com", false},
+		{"javascript", "javascript:alert(1)", false},
+		{"no host", "https:///path", false},
+		{"too long", "https://example.com/" + strings.Repeat("a", defaultMaxURLLength), false},
+		{"own host", "https://SHO.RT./abc", false},
+		{"blocked domain", "https://evil.com", false},
+		{"blocked subdomain", "https://www.evil.com", false},
+		{"suffix is not a subdomain", "https://notevil.com", true},
+		{"localhost", "http://localhost:8080", false},
+		{"localhost subdomain", "http://app.localhost", false},
+		{"loopback", "http://127.0.0.1", false},
+		{"private", "http://10.1.2.3", false},
+		{"link local", "http://169.254.169.254/latest/meta-data", false},
+		{"unspecified", "http://0.0.0.0", false},
+		{"ipv6 loopback", "http://[::1]", false},
+		{"ipv6 unique local", "http://[fd00::1]", false},
+		{"cgnat", "http://100.64.0.1", false},
+		{"cgnat upper bound", "http://100.127.255.255", false},
+		{"just past cgnat", "http://100.128.0.1", true},
+		{"public ip", "http://93.184.216.34", true},
+		{"decimal ip", "http://2130706433", false},
+		{"hex ip", "http://0x7f.1", false},
+		{"octal ip", "http://0177.0.0.1", false},
+		{"numeric last label", "http://example.123", false},
+		{"hex-looking tld", "http://example.cafe", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(context.Background(), tt.url)
+			if tt.allowed && err != nil {
+				t.Errorf("%s: got %v, want allowed", tt.url, err)
+			}
+			if !tt.allowed && err == nil {
+				t.Errorf("%s: allowed, want refused", tt.url)
+			}
+		})
+	}
+}
+
+func TestURLPolicyAllowList(t *testing.T) {
+	p := NewURLPolicy()
+	p.allow = []string{"example.com"}
+	p.block = []string{"bad.example.com"}
+
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"example.com", true},
+		{"docs.example.com", true},
+		{"bad.example.com", false},
+		{"other.com", false},
+		{"example.com.other.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			err := p.Check(context.Background(), "https://"+tt.host+"/")
+			if tt.allowed != (err == nil) {
+				t.Errorf("got %v, want allowed: %v", err, tt.allowed)
+			}
+			if err != nil && !errors.Is(err, errURLNotAllowed) {
+				t.Errorf("got %v, want it to wrap %v", err, errURLNotAllowed)
+			}
+		})
+	}
+}
+
+func TestURLPolicyResolvedHosts(t *testing.T) {
+	p := NewURLPolicy()
+	p.resolveHosts = true
+
+	ctx := context.WithValue(context.Background(), hostLookupsKey{}, hostLookups{
+		"public.test":   {addrs: []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}},
+		"internal.test": {addrs: []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("10.0.0.1")}}},
+		"cgnat.test":    {addrs: []net.IPAddr{{IP: net.ParseIP("100.100.100.100")}}},
+		"missing.test":  {err: errors.New("no such host")},
+	})
+
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"public.test", true},
+		{"internal.test", false},
+		{"cgnat.test", false},
+		{"missing.test", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			err := p.Check(ctx, "https://"+tt.host+"/")
+			if tt.allowed != (err == nil) {
+				t.Errorf("got %v, want allowed: %v", err, tt.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRSize   = 256
+	minQRSize       = 64
+	maxQRSize       = 2048
+	defaultQRMargin = 4
+	maxQRMargin     = 16
+	qrCacheSize     = 1024
+)
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+type qrOptions struct {
+	format string
+	size   int
+	level  string
+	margin int
+}
+
+func parseQROptions(r *http.Request) (qrOptions, error) {
+	params := r.URL.Query()
+	opts := qrOptions{format: "png", size: defaultQRSize, level: "M", margin: defaultQRMargin}
+
+	if v := params.Get("format"); v != "" {
+		if v != "png" && v != "svg" {
+			return opts, fmt.Errorf("format must be 'png' or 'svg'")
+		}
+		opts.format = v
+	}
+	if v := params.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minQRSize || n > maxQRSize {
+			return opts, fmt.Errorf("size must be between %d and %d", minQRSize, maxQRSize)
+		}
+		opts.size = n
+	}
+	if v := params.Get("ec"); v != "" {
+		v = strings.ToUpper(v)
+		if _, ok := qrLevels[v]; !ok {
+			return opts, fmt.Errorf("ec must be one of L, M, Q or H")
+		}
+		opts.level = v
+	}
+	if v := params.Get("margin"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxQRMargin {
+			return opts, fmt.Errorf("margin must be between 0 and %d modules", maxQRMargin)
+		}
+		opts.margin = n
+	}
+	return opts, nil
+}
+
+// QRCodeHandler serves a QR code of the full short URL for an existing key.
+// Query parameters: format (png or svg), size in pixels, ec (error
+// correction level L, M, Q or H) and margin in modules.
+func (s *Server) QRCodeHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	opts, err := parseQROptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mapping, err := s.urlStore.Get(r.Context(), key)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if mapping.IsExpired(time.Now()) {
+		http.Error(w, "this link has expired", http.StatusGone)
+		return
+	}
+
+	content := s.shortURL(r, key)
+	cacheKey := fmt.Sprintf("%s|%s|%d|%s|%d", content, opts.format, opts.size, opts.level, opts.margin)
+
+	data, ok := s.qrCache.get(cacheKey)
+	if !ok {
+		data, err = renderQRCode(content, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.qrCache.add(cacheKey, data)
+	}
+
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	// Without BASE_URL the code depends on the request's host, so shared
+	// caches must not hand it to other clients.
+	if s.baseURL != "" {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	} else {
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+	}
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if opts.format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+	}
+	w.Write(data)
+}
+
+// shortURL returns the public URL of key, from BASE_URL when set.
+// Otherwise it is built from the request, taking the scheme and host from
+// X-Forwarded-Proto and X-Forwarded-Host only when a trusted proxy sent it.
+func (s *Server) shortURL(r *http.Request, key string) string {
+	if s.baseURL != "" {
+		return s.baseURL + "/" + key
+	}
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if s.fromTrustedProxy(r) {
+		if v := forwardedValue(r.Header.Get("X-Forwarded-Proto")); v == "http" || v == "https" {
+			scheme = v
+		}
+		if v := forwardedValue(r.Header.Get("X-Forwarded-Host")); v != "" {
+			host = v
+		}
+	}
+	return scheme + "://" + host + "/" + key
+}
+
+// forwardedValue returns the value set by the proxy closest to the client.
+func forwardedValue(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+func renderQRCode(content string, opts qrOptions) ([]byte, error) {
+	code, err := qrcode.New(content, qrLevels[opts.level])
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	modules := code.Bitmap()
+
+	total := len(modules) + 2*opts.margin
+	if opts.size < total {
+		return nil, fmt.Errorf("size must be at least %d for this code", total)
+	}
+
+	dark := func(x, y int) bool {
+		x, y = x-opts.margin, y-opts.margin
+		return y >= 0 && y < len(modules) && x >= 0 && x < len(modules) && modules[y][x]
+	}
+
+	var buf bytes.Buffer
+	if opts.format == "svg" {
+		fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+			opts.size, opts.size, total, total)
+		fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, total, total)
+		for y := 0; y < total; y++ {
+			for x := 0; x < total; x++ {
+				if dark(x, y) {
+					fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+				}
+			}
+		}
+		buf.WriteString(`"/></svg>`)
+		return buf.Bytes(), nil
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, opts.size, opts.size), color.Palette{color.White, color.Black})
+	for py := 0; py < opts.size; py++ {
+		for px := 0; px < opts.size; px++ {
+			if dark(px*total/opts.size, py*total/opts.size) {
+				img.SetColorIndex(px, py, 1)
+			}
+		}
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// qrCache keeps recently rendered QR codes. A code depends only on the
+// short URL and rendering options, so entries never go stale.
+type qrCache struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type qrCacheEntry struct {
+	key  string
+	data []byte
+}
+
+func newQRCache() *qrCache {
+	return &qrCache{order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *qrCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*qrCacheEntry).data, true
+}
+
+func (c *qrCache) add(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&qrCacheEntry{key: key, data: data})
+	if c.order.Len() > qrCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*qrCacheEntry).key)
+	}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
+)
+
+const (
+	clickQuotaRefresh      = time.Minute
+	clickQuotaTimeout      = 5 * time.Second
+	clickQuotaIdle         = 10 * time.Minute
+	maxClickQuotaRefreshes = 8
+)
+
+// monthStart returns the start of the current quota period.
+func monthStart(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// linkBudget loads what owner may still create. The store enforces the
+// same limits when inserting; checking first gives per-item errors without
+// touching the store. Workspace links are not metered, so workspaces get a
+// nil, unlimited budget.
+func (s *Server) linkBudget(ctx context.Context, owner models.LinkOwner) (*store.LinkBudget, error) {
+	if owner.WorkspaceID != "" {
+		return nil, nil
+	}
+	usage, err := s.quotas.GetUsage(ctx, owner.UserID, monthStart(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	return store.NewLinkBudget(usage), nil
+}
+
+// clickQuota counts each link owner's clicks this month in process. The
+// count is corrected from the store in the background about once a minute
+// per owner, so redirects never wait on it.
+type clickQuota struct {
+	mu        sync.Mutex
+	owners    map[string]*clickAllowance
+	refreshes chan struct{}
+	sweep     time.Time
+}
+
+type clickAllowance struct {
+	limit      int64
+	used       int64
+	period     time.Time
+	checked    time.Time
+	seen       time.Time
+	loaded     bool
+	refreshing bool
+	// pending counts clicks allowed since the running refresh started,
+	// which its count from the store may not include.
+	pending int64
+}
+
+func newClickQuota() *clickQuota {
+	return &clickQuota{
+		owners:    make(map[string]*clickAllowance),
+		refreshes: make(chan struct{}, maxClickQuotaRefreshes),
+	}
+}
+
+// allowClick reports whether a click on a link owned by ownerID should be
+// recorded. Clicks over the plan's monthly limit still redirect; they are
+// just not tracked.
+func (s *Server) allowClick(ownerID string) bool {
+	if ownerID == "" {
+		return true
+	}
+
+	now := time.Now()
+	period := monthStart(now)
+	q := s.clickQuota
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweepStale(now)
+
+	a, ok := q.owners[ownerID]
+	if !ok || !a.period.Equal(period) {
+		a = &clickAllowance{period: period}
+		q.owners[ownerID] = a
+	}
+	a.seen = now
+
+	if !a.refreshing && now.Sub(a.checked) >= clickQuotaRefresh {
+		select {
+		case q.refreshes <- struct{}{}:
+			a.refreshing, a.checked, a.pending = true, now, 0
+			go s.refreshClickAllowance(ownerID, a)
+		default:
+			// Too many refreshes running; try again on a later click.
+		}
+	}
+
+	// Until the first refresh lands the owner's plan is unknown, and
+	// tracking a click too many is better than losing one.
+	allowed := !a.loaded || a.limit == 0 || a.used < a.limit
+	if allowed {
+		a.used++
+		a.pending++
+	}
+	return allowed
+}
+
+func (s *Server) refreshClickAllowance(ownerID string, a *clickAllowance) {
+	q := s.clickQuota
+	defer func() { <-q.refreshes }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), clickQuotaTimeout)
+	defer cancel()
+	usage, err := s.quotas.GetUsage(ctx, ownerID, a.period)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	a.refreshing = false
+	if err != nil {
+		log.Printf("[quota] failed to load usage for %s: %v", ownerID, err)
+		return
+	}
+	a.limit = models.LookupPlan(usage.Plan).MaxMonthlyClicks
+	a.used = usage.MonthlyClicks + a.pending
+	a.loaded = true
+}
+
+// sweepStale drops owners without recent clicks now and then so the map
+// does not grow with every owner ever clicked. Callers must hold q.mu.
+func (q *clickQuota) sweepStale(now time.Time) {
+	if now.Sub(q.sweep) < time.Minute {
+		return
+	}
+	q.sweep = now
+
+	for ownerID, a := range q.owners {
+		if now.Sub(a.seen) > clickQuotaIdle {
+			delete(q.owners, ownerID)
+		}
+	}
+}
+
+// UsageHandler reports the current user's plan and usage for this month.
+func (s *Server) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	start := monthStart(time.Now())
+	usage, err := s.quotas.GetUsage(r.Context(), user.ID, start)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	plan := models.LookupPlan(usage.Plan)
+	resp := models.UsageResponse{
+		Plan:          plan.Name,
+		PeriodStart:   start,
+		PeriodEnd:     start.AddDate(0, 1, 0),
+		Links:         models.UsageCount{Used: usage.Links, Limit: plan.MaxLinks},
+		CustomKeys:    models.UsageCount{Used: usage.CustomKeys, Limit: plan.MaxCustomKeys},
+		MonthlyClicks: models.UsageCount{Used: usage.MonthlyClicks, Limit: plan.MaxMonthlyClicks},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
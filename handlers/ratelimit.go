@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/store"
+)
+
+// RateLimit allows Limit requests per Window. A zero Limit disables it.
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+type RateLimits struct {
+	Create     RateLimit
+	BulkCreate RateLimit
+	Redirect   RateLimit
+	Login      RateLimit
+}
+
+// LoadRateLimits reads RATE_LIMIT_CREATE, RATE_LIMIT_BULK_CREATE,
+// RATE_LIMIT_REDIRECT and RATE_LIMIT_LOGIN, each written as "LIMIT/WINDOW"
+// such as "60/1m", or "off" to disable.
+func LoadRateLimits() (RateLimits, error) {
+	var limits RateLimits
+	var err error
+
+	if limits.Create, err = parseRateLimit("RATE_LIMIT_CREATE", "60/1m"); err != nil {
+		return limits, err
+	}
+	if limits.BulkCreate, err = parseRateLimit("RATE_LIMIT_BULK_CREATE", "10/1h"); err != nil {
+		return limits, err
+	}
+	if limits.Redirect, err = parseRateLimit("RATE_LIMIT_REDIRECT", "600/1m"); err != nil {
+		return limits, err
+	}
+	if limits.Login, err = parseRateLimit("RATE_LIMIT_LOGIN", "10/15m"); err != nil {
+		return limits, err
+	}
+	return limits, nil
+}
+
+func parseRateLimit(name, fallback string) (RateLimit, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		v = fallback
+	}
+	if v == "off" {
+		return RateLimit{}, nil
+	}
+
+	limit, window, ok := strings.Cut(v, "/")
+	n, err := strconv.Atoi(limit)
+	if !ok || err != nil || n <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid %s: %q", name, v)
+	}
+	return RateLimit{Limit: n, Window: d}, nil
+}
+
+func (s *Server) SetRateLimiter(limiter store.RateLimiter, limits RateLimits) {
+	s.limiter = limiter
+	s.limits = limits
+}
+
+// LimitCreates limits link creation per user. It must run inside
+// RequireAuth.
+func (s *Server) LimitCreates(next http.HandlerFunc) http.HandlerFunc {
+	return s.rateLimit("create", func() RateLimit { return s.limits.Create }, s.userOrIP, next)
+}
+
+// LimitBulkCreates limits bulk creates and imports per user, which can
+// create thousands of links each and so are not counted against the
+// single-create limit. It must run inside RequireAuth.
+func (s *Server) LimitBulkCreates(next http.HandlerFunc) http.HandlerFunc {
+	return s.rateLimit("bulk_create", func() RateLimit { return s.limits.BulkCreate }, s.userOrIP, next)
+}
+
+func (s *Server) userOrIP(r *http.Request) string {
+	if user := GetCurrentUser(r); user != nil {
+		return user.ID
+	}
+	return "ip:" + s.clientIP(r)
+}
+
+// LimitRedirects limits redirects and other public lookups per client IP.
+func (s *Server) LimitRedirects(next http.HandlerFunc) http.HandlerFunc {
+	return s.rateLimit("redirect", func() RateLimit { return s.limits.Redirect }, s.clientIP, next)
+}
+
+// LimitLogins limits login attempts per client IP.
+func (s *Server) LimitLogins(next http.HandlerFunc) http.HandlerFunc {
+	return s.rateLimit("login", func() RateLimit { return s.limits.Login }, s.clientIP, next)
+}
+
+func (s *Server) rateLimit(name string, limit func() RateLimit, identify func(*http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rl := limit()
+		if s.limiter == nil || rl.Limit == 0 {
+			next(w, r)
+			return
+		}
+
+		result, err := s.limiter.Allow(r.Context(), name+":"+identify(r), rl.Limit, rl.Window)
+		if err != nil {
+			// Better to serve the request than to fail closed on a limiter fault.
+			log.Printf("[ratelimit] %s check failed: %v", name, err)
+			next(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rl.Limit, int(rl.Window.Seconds())))
+		h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// LoadTrustedProxies parses TRUSTED_PROXIES, a comma-separated list of
+// CIDRs or addresses whose X-Forwarded-For headers are believed.
+func LoadTrustedProxies() ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, v := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", v)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (s *Server) SetTrustedProxies(proxies []*net.IPNet) {
+	s.trustedProxies = proxies
+}
+
+func (s *Server) isTrustedProxy(ip net.IP) bool {
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromTrustedProxy reports whether the connection comes from a trusted
+// proxy, whose forwarding headers may be believed.
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && s.isTrustedProxy(ip)
+}
+
+// clientIP returns the address of the client. When the connection comes
+// from a trusted proxy, X-Forwarded-For is walked from the right and the
+// first address not belonging to a trusted proxy is used.
+func (s *Server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !s.isTrustedProxy(ip) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		if !s.isTrustedProxy(hop) {
+			return hop.String()
+		}
+		host = hop.String()
+	}
+	return host
+}
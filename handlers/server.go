@@ -1,45 +1,143 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/JamieLeeNZ/url-shortener/models"
 	"github.com/JamieLeeNZ/url-shortener/store"
-	"github.com/redis/go-redis/v9"
 )
 
 type Server struct {
-	urlStore    store.URLStore
-	userStore   store.UserStore
-	redisClient *redis.Client
+	urlStore   store.URLStore
+	userStore  store.UserStore
+	sessions   store.SessionStore
+	clicks     store.ClickStore
+	keys       store.KeyGenerator
+	tokens     store.TokenStore
+	history    store.HistoryStore
+	quotas     store.QuotaStore
+	workspaces store.WorkspaceStore
+	providers  map[string]LoginProvider
+	checks     []readinessCheck
+	policy     *URLPolicy
+	qrCache    *qrCache
+	clickQuota *clickQuota
+	baseURL    string
+	ipHashSalt string
+
+	limiter        store.RateLimiter
+	limits         RateLimits
+	trustedProxies []*net.IPNet
 }
 
-func NewServer(urlStore store.URLStore, userStore store.UserStore, redisClient *redis.Client) *Server {
+func NewServer(urlStore store.URLStore, userStore store.UserStore, sessions store.SessionStore, clicks store.ClickStore, keys store.KeyGenerator, tokens store.TokenStore, history store.HistoryStore, quotas store.QuotaStore, workspaces store.WorkspaceStore) *Server {
 	return &Server{
-		urlStore:    urlStore,
-		userStore:   userStore,
-		redisClient: redisClient,
+		urlStore:   urlStore,
+		userStore:  userStore,
+		sessions:   sessions,
+		clicks:     clicks,
+		keys:       keys,
+		tokens:     tokens,
+		history:    history,
+		quotas:     quotas,
+		workspaces: workspaces,
+		providers:  make(map[string]LoginProvider),
+		policy:     NewURLPolicy(),
+		qrCache:    newQRCache(),
+		clickQuota: newClickQuota(),
+		baseURL:    strings.TrimRight(os.Getenv("BASE_URL"), "/"),
+		ipHashSalt: os.Getenv("IP_HASH_SALT"),
 	}
 }
 
-func generateRandomKey(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[rand.Intn(len(charset))]
+const (
+	customKeyMinLength = 3
+	customKeyMaxLength = 32
+	maxKeyAttempts     = 5
+)
+
+var reservedKeys = map[string]bool{
+	"login":      true,
+	"logout":     true,
+	"links":      true,
+	"me":         true,
+	"health":     true,
+	"auth":       true,
+	"tokens":     true,
+	"metrics":    true,
+	"healthz":    true,
+	"readyz":     true,
+	"workspaces": true,
+}
+
+func validateCustomKey(key string) error {
+	if len(key) < customKeyMinLength || len(key) > customKeyMaxLength {
+		return fmt.Errorf("custom key must be between %d and %d characters", customKeyMinLength, customKeyMaxLength)
+	}
+
+	for _, c := range key {
+		isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlnum && c != '-' && c != '_' {
+			return fmt.Errorf("custom key may only contain letters, digits, '-' and '_'")
+		}
 	}
-	return string(b)
+
+	if reservedKeys[strings.ToLower(key)] {
+		return fmt.Errorf("custom key %q is reserved", key)
+	}
+	return nil
 }
 
-func (s *Server) HealthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{"status": "OK"}
-	json.NewEncoder(w).Encode(response)
+// createMapping inserts mapping, drawing a key from the key generator unless
+// a custom key was given. Generated keys that turn out to be taken (for
+// example by a custom alias) are skipped.
+func (s *Server) createMapping(ctx context.Context, mapping models.URLMapping) (models.URLMapping, error) {
+	mapping.Custom = mapping.Key != ""
+	if mapping.Key != "" {
+		return mapping, s.urlStore.Set(ctx, mapping)
+	}
+
+	for attempt := 0; attempt < maxKeyAttempts; attempt++ {
+		key, err := s.keys.NextKey(ctx)
+		if err != nil {
+			return mapping, err
+		}
+
+		mapping.Key = key
+		err = s.urlStore.Set(ctx, mapping)
+		if !errors.Is(err, store.ErrKeyTaken) {
+			return mapping, err
+		}
+	}
+	return mapping, fmt.Errorf("no free key found after %d attempts", maxKeyAttempts)
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "URL not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errForbidden), errors.Is(err, store.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, store.ErrUnavailable):
+		log.Println("store unavailable:", err)
+		http.Error(w, "service temporarily unavailable", http.StatusServiceUnavailable)
+	default:
+		log.Println("store error:", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
 }
 
 func (s *Server) CreateHandler(w http.ResponseWriter, r *http.Request) {
@@ -58,26 +156,59 @@ func (s *Server) CreateHandler(w http.ResponseWriter, r *http.Request) {
 
 	db := s.urlStore
 
-	req, err := parseAndValidateURL(r)
+	req, err := s.parseAndValidateURL(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var key string
+	if req.CustomKey != "" {
+		if err := validateCustomKey(req.CustomKey); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	if k, _, found := db.GetKeyFromOriginal(ctx, req.Original); found {
-		key = k
-	} else {
-		key = generateRandomKey(6)
-		for db.ContainsKey(ctx, key) {
-			key = generateRandomKey(6)
+	owner, err := s.requestOwner(r, user, models.RoleEditor)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	existing, err := db.GetByOriginal(ctx, owner, req.Original)
+	key := existing.Key
+	if err == nil && req.CustomKey != "" && key != req.CustomKey {
+		http.Error(w, fmt.Sprintf("original URL is already shortened as %q", key), http.StatusConflict)
+		return
+	} else if errors.Is(err, store.ErrNotFound) {
+		budget, err := s.linkBudget(ctx, owner)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if err := budget.Take(req.CustomKey != ""); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
 		}
 
-		if err := db.Set(ctx, key, req.Original, user.ID); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		mapping, err := s.createMapping(ctx, models.URLMapping{
+			Key:         req.CustomKey,
+			Original:    req.Original,
+			UserID:      user.ID,
+			WorkspaceID: owner.WorkspaceID,
+			ExpiresAt:   req.ExpiresAt,
+		})
+		if errors.Is(err, store.ErrKeyTaken) {
+			http.Error(w, "custom key is already in use", http.StatusConflict)
+			return
+		} else if err != nil {
+			writeStoreError(w, err)
 			return
 		}
+		key = mapping.Key
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
 	}
 
 	resp := models.URLShortenResponse{Key: key}
@@ -99,13 +230,19 @@ func (s *Server) GetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	original, _, ok := s.urlStore.GetOriginalFromKey(ctx, key)
-	if !ok {
-		http.Error(w, "invalid URL", http.StatusNotFound)
+	mapping, err := s.urlStore.Get(ctx, key)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if mapping.IsExpired(time.Now()) {
+		http.Error(w, "this link has expired", http.StatusGone)
 		return
 	}
 
-	http.Redirect(w, r, original, http.StatusFound)
+	s.recordClick(r, mapping)
+	http.Redirect(w, r, mapping.Original, http.StatusFound)
 }
 
 func (s *Server) UpdateHandler(w http.ResponseWriter, r *http.Request) {
@@ -128,21 +265,19 @@ func (s *Server) UpdateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req, err := parseAndValidateURL(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if _, err := s.authorizeLink(ctx, user, key, models.RoleEditor); err != nil {
+		writeStoreError(w, err)
 		return
 	}
 
-	_, existingUserID, found := s.urlStore.GetKeyFromOriginal(ctx, req.Original)
-	if found && existingUserID != user.ID {
-		http.Error(w, "forbidden: you do not own this URL", http.StatusForbidden)
+	req, err := s.parseAndValidateURL(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	success := s.urlStore.Update(ctx, key, req.Original)
-	if !success {
-		http.Error(w, "key not found or new URL already mapped to a different key", http.StatusNotFound)
+	if err := s.urlStore.Update(ctx, key, req.Original, req.ExpiresAt, req.ClearExpiry, user.ID); err != nil {
+		writeStoreError(w, err)
 		return
 	}
 
@@ -170,23 +305,19 @@ func (s *Server) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, existingUserID, found := s.urlStore.GetOriginalFromKey(ctx, key)
-	if !found {
-		http.Error(w, "URL not found", http.StatusNotFound)
-		return
-	} else if existingUserID != user.ID {
-		http.Error(w, "forbidden: you do not own this URL", http.StatusForbidden)
+	if _, err := s.authorizeLink(ctx, user, key, models.RoleEditor); err != nil {
+		writeStoreError(w, err)
 		return
 	}
 
-	if !s.urlStore.Delete(ctx, key) {
-		http.Error(w, "failed to delete URL", http.StatusInternalServerError)
+	if err := s.urlStore.Delete(ctx, key); err != nil {
+		writeStoreError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func parseAndValidateURL(r *http.Request) (models.URLShortenRequest, error) {
+func (s *Server) parseAndValidateURL(r *http.Request) (models.URLShortenRequest, error) {
 	var req models.URLShortenRequest
 
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -194,15 +325,79 @@ func parseAndValidateURL(r *http.Request) (models.URLShortenRequest, error) {
 		return req, fmt.Errorf("invalid JSON")
 	}
 
+	return req, s.validateShortenRequest(r.Context(), req)
+}
+
+func (s *Server) validateShortenRequest(ctx context.Context, req models.URLShortenRequest) error {
 	if req.Original == "" {
-		return req, fmt.Errorf("original URL is required")
+		return fmt.Errorf("original URL is required")
 	}
 
 	if _, err := url.ParseRequestURI(req.Original); err != nil {
-		return req, fmt.Errorf("invalid URL format")
+		return fmt.Errorf("invalid URL format")
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+
+	return s.policy.Check(ctx, req.Original)
+}
+
+const (
+	defaultLinksPageSize = 50
+	maxLinksPageSize     = 200
+)
+
+func parseLinkListQuery(r *http.Request) (models.LinkListQuery, error) {
+	params := r.URL.Query()
+	query := models.LinkListQuery{
+		Limit:  defaultLinksPageSize,
+		Cursor: params.Get("cursor"),
+		SortBy: params.Get("sort"),
+		Search: params.Get("q"),
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxLinksPageSize {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxLinksPageSize)
+		}
+		query.Limit = limit
+	}
+
+	switch query.SortBy {
+	case "":
+		query.SortBy = store.SortByCreated
+	case store.SortByCreated, store.SortByClicks, store.SortByKey:
+	default:
+		return query, fmt.Errorf("sort must be one of created, clicks or key")
+	}
+
+	switch params.Get("order") {
+	case "":
+		query.Descending = query.SortBy != store.SortByKey
+	case "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
 	}
 
-	return req, nil
+	for name, dst := range map[string]**time.Time{
+		"created_from": &query.CreatedFrom,
+		"created_to":   &query.CreatedTo,
+	} {
+		if v := params.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*dst = &t
+		}
+	}
+
+	return query, nil
 }
 
 func (s *Server) ListUserLinks(w http.ResponseWriter, r *http.Request) {
@@ -212,12 +407,28 @@ func (s *Server) ListUserLinks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	urls, err := s.userStore.GetURLsByUserID(r.Context(), user.ID)
+	query, err := parseLinkListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	owner, err := s.requestOwner(r, user, models.RoleViewer)
 	if err != nil {
-		http.Error(w, "Failed to fetch URLs", http.StatusInternalServerError)
+		writeStoreError(w, err)
+		return
+	}
+	query.WorkspaceID = owner.WorkspaceID
+
+	page, err := s.userStore.ListURLsByUserID(r.Context(), user.ID, query)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(urls)
+	json.NewEncoder(w).Encode(page)
 }
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
+)
+
+func TestParseLinkListQuery(t *testing.T) {
+	from := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    models.LinkListQuery
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  models.LinkListQuery{Limit: defaultLinksPageSize, SortBy: store.SortByCreated, Descending: true},
+		},
+		{
+			name:  "key sorts ascending by default",
+			query: "sort=key",
+			want:  models.LinkListQuery{Limit: defaultLinksPageSize, SortBy: store.SortByKey},
+		},
+		{
+			name:  "clicks sort descending by default",
+			query: "sort=clicks",
+			want:  models.LinkListQuery{Limit: defaultLinksPageSize, SortBy: store.SortByClicks, Descending: true},
+		},
+		{
+			name:  "explicit order",
+			query: "sort=created&order=asc",
+			want:  models.LinkListQuery{Limit: defaultLinksPageSize, SortBy: store.SortByCreated},
+		},
+		{
+			name:  "everything",
+			query: "limit=5&cursor=abc&q=docs&order=desc&sort=key&created_from=2026-01-02T03:04:05Z",
+			want: models.LinkListQuery{
+				Limit: 5, Cursor: "abc", Search: "docs", SortBy: store.SortByKey, Descending: true, CreatedFrom: &from,
+			},
+		},
+		{name: "limit too small", query: "limit=0", wantErr: true},
+		{name: "limit too large", query: "limit=100000", wantErr: true},
+		{name: "limit not a number", query: "limit=ten", wantErr: true},
+		{name: "unknown sort", query: "sort=original_url", wantErr: true},
+		{name: "unknown order", query: "order=up", wantErr: true},
+		{name: "bad date", query: "created_to=yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLinkListQuery(httptest.NewRequest("GET", "/links?"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !sameTime(got.CreatedFrom, tt.want.CreatedFrom) || !sameTime(got.CreatedTo, tt.want.CreatedTo) {
+				t.Errorf("dates: got %v-%v, want %v-%v", got.CreatedFrom, got.CreatedTo, tt.want.CreatedFrom, tt.want.CreatedTo)
+			}
+			got.CreatedFrom, got.CreatedTo = nil, nil
+			tt.want.CreatedFrom, tt.want.CreatedTo = nil, nil
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func TestLinkListPagesThroughEveryLink(t *testing.T) {
+	_, m := newTestServer(t)
+	alice := createTestUser(t, m, "alice")
+	for _, key := range []string{"e", "b", "d", "a", "c"} {
+		createTestLink(t, m, models.URLMapping{Key: key, Original: "https://example.com/" + key, UserID: alice.ID})
+	}
+
+	for _, sortBy := range []string{store.SortByCreated, store.SortByClicks, store.SortByKey} {
+		t.Run(sortBy, func(t *testing.T) {
+			query, err := parseLinkListQuery(httptest.NewRequest("GET", "/links?limit=2&sort="+sortBy, nil))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			seen := make(map[string]bool)
+			for pages := 0; ; pages++ {
+				if pages > 3 {
+					t.Fatalf("cursor did not advance")
+				}
+				page, err := m.ListURLsByUserID(context.Background(), alice.ID, query)
+				if err != nil {
+					t.Fatalf("list: %v", err)
+				}
+				for _, link := range page.Links {
+					if seen[link.Key] {
+						t.Errorf("%s listed twice", link.Key)
+					}
+					seen[link.Key] = true
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			if len(seen) != 5 {
+				t.Errorf("listed %d links, want 5", len(seen))
+			}
+		})
+	}
+}
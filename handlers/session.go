@@ -3,10 +3,13 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
 	"github.com/google/uuid"
 )
 
@@ -14,28 +17,21 @@ type contextKey string
 
 const (
 	sessionCookieName = "session_id"
-	sessionPrefix     = "session:"
-	sessionDuration   = 24 * time.Hour
+	SessionDuration   = 24 * time.Hour
 	userContextKey    = contextKey("user")
 )
 
 func (s *Server) createSession(w http.ResponseWriter, ctx context.Context, user models.User) error {
 	sessionID := uuid.New().String()
 
-	data, err := json.Marshal(user)
-	if err != nil {
-		return err
-	}
-
-	err = s.redisClient.Set(ctx, sessionPrefix+sessionID, data, sessionDuration).Err()
-	if err != nil {
+	if err := s.sessions.SetSession(ctx, sessionID, user, SessionDuration); err != nil {
 		return err
 	}
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     sessionCookieName,
 		Value:    sessionID,
-		Expires:  time.Now().Add(sessionDuration),
+		Expires:  time.Now().Add(SessionDuration),
 		HttpOnly: true,
 		Secure:   false, // set to true in production with HTTPS
 		Path:     "/",
@@ -50,25 +46,29 @@ func (s *Server) getSessionUser(r *http.Request) (*models.User, error) {
 		return nil, err
 	}
 
-	data, err := s.redisClient.Get(r.Context(), sessionPrefix+cookie.Value).Result()
+	user, err := s.sessions.GetSession(r.Context(), cookie.Value)
 	if err != nil {
 		return nil, err
 	}
 
-	var user models.User
-	if err := json.Unmarshal([]byte(data), &user); err != nil {
-		return nil, err
-	}
-
-	s.redisClient.Expire(r.Context(), sessionPrefix+cookie.Value, sessionDuration)
+	s.sessions.TouchSession(r.Context(), cookie.Value, SessionDuration)
 
 	return &user, nil
 }
 
 func (s *Server) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if header := r.Header.Get("Authorization"); header != "" {
+			s.authenticateToken(w, r, header, next)
+			return
+		}
+
 		user, err := s.getSessionUser(r)
-		if err != nil {
+		if errors.Is(err, store.ErrUnavailable) {
+			log.Println("session store unavailable:", err)
+			http.Error(w, "service temporarily unavailable", http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
 			http.Redirect(w, r, "/login", http.StatusFound)
 			return
 		}
@@ -98,7 +98,7 @@ func (s *Server) MeHandler(w http.ResponseWriter, r *http.Request) {
 func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie(sessionCookieName)
 	if err == nil {
-		s.redisClient.Del(r.Context(), sessionPrefix+cookie.Value)
+		s.sessions.DeleteSession(r.Context(), cookie.Value)
 	}
 
 	http.SetCookie(w, &http.Cookie{
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+const defaultStatsWindow = 30 * 24 * time.Hour
+
+func (s *Server) recordClick(r *http.Request, mapping models.URLMapping) {
+	// Workspace links are not metered, so their clicks are always kept.
+	if mapping.WorkspaceID == "" && !s.allowClick(mapping.UserID) {
+		return
+	}
+
+	event := models.ClickEvent{
+		Key:       mapping.Key,
+		ClickedAt: time.Now(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IPHash:    s.hashIP(s.clientIP(r)),
+	}
+	s.clicks.RecordClicks(r.Context(), []models.ClickEvent{event})
+}
+
+func (s *Server) hashIP(ip string) string {
+	sum := sha256.Sum256([]byte(s.ipHashSalt + ip))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Server) LinkStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	key := r.PathValue("key")
+
+	_, err := s.authorizeLink(ctx, user, key, models.RoleViewer)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "day"
+	} else if interval != "hour" && interval != "day" {
+		http.Error(w, "interval must be 'hour' or 'day'", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now()
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "to must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	from := to.Add(-defaultStatsWindow)
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "from must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	stats, err := s.clicks.GetClickStats(ctx, key, interval, from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
+	"github.com/google/uuid"
+)
+
+const (
+	apiTokenPrefix     = "us_"
+	tokenContextKey    = contextKey("token")
+	maxTokenNameLength = 100
+)
+
+func generateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func requiredScope(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return models.ScopeRead
+	default:
+		return models.ScopeWrite
+	}
+}
+
+// authenticateToken handles requests carrying an Authorization header. A
+// bad token is always rejected outright rather than falling back to the
+// session cookie.
+func (s *Server) authenticateToken(w http.ResponseWriter, r *http.Request, header string, next http.HandlerFunc) {
+	secret, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || secret == "" {
+		http.Error(w, "unsupported authorization scheme", http.StatusUnauthorized)
+		return
+	}
+
+	user, token, err := s.tokens.AuthenticateToken(r.Context(), hashAPIToken(secret))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if scope := requiredScope(r); !token.HasScope(scope) {
+		http.Error(w, "token is missing the "+scope+" scope", http.StatusForbidden)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, &user)
+	ctx = context.WithValue(ctx, tokenContextKey, &token)
+	next(w, r.WithContext(ctx))
+}
+
+func currentToken(r *http.Request) *models.APIToken {
+	token, _ := r.Context().Value(tokenContextKey).(*models.APIToken)
+	return token
+}
+
+func validateTokenRequest(req models.CreateTokenRequest) error {
+	if req.Name == "" || len(req.Name) > maxTokenNameLength {
+		return errors.New("name is required and must be at most 100 characters")
+	}
+	if len(req.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if scope != models.ScopeRead && scope != models.ScopeWrite {
+			return errors.New("scopes must be read or write")
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+func (s *Server) CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if currentToken(r) != nil {
+		http.Error(w, "API tokens cannot be used to manage API tokens", http.StatusForbidden)
+		return
+	}
+
+	var req models.CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validateTokenRequest(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	secret, err := generateAPIToken()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := s.tokens.CreateToken(r.Context(), models.APIToken{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}, hashAPIToken(secret))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreateTokenResponse{APIToken: token, Token: secret})
+}
+
+func (s *Server) ListTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if currentToken(r) != nil {
+		http.Error(w, "API tokens cannot be used to manage API tokens", http.StatusForbidden)
+		return
+	}
+
+	tokens, err := s.tokens.ListTokens(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (s *Server) RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if currentToken(r) != nil {
+		http.Error(w, "API tokens cannot be used to manage API tokens", http.StatusForbidden)
+		return
+	}
+
+	if err := s.tokens.RevokeToken(r.Context(), user.ID, r.PathValue("id")); errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "token not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
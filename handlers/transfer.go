@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
+)
+
+const (
+	maxImportBodyBytes = 4 << 20
+
+	// Imports over HTTP run within the server's write timeout, so larger
+	// files go through the import command instead.
+	MaxImportRecords     = 50000
+	maxHTTPImportRecords = 1000
+)
+
+// dryRunKey stands in for keys that would be generated on a real import.
+const dryRunKey = "(generated)"
+
+var exportCSVHeader = []string{"key", "original_url", "created_at", "expires_at"}
+
+// Import column names, after lower-casing and replacing spaces and dashes
+// with underscores. The aliases cover Bitly-style exports.
+var (
+	importKeyColumns      = []string{"key", "short_key", "custom_key", "bitlink", "link", "short_url", "back_half"}
+	importOriginalColumns = []string{"original_url", "long_url", "url", "destination", "destination_url", "target"}
+	importExpiresColumns  = []string{"expires_at", "expiration", "expires"}
+)
+
+func (s *Server) ExportLinksHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	} else if format != "json" && format != "csv" {
+		http.Error(w, "format must be 'json' or 'csv'", http.StatusBadRequest)
+		return
+	}
+
+	owner, err := s.requestOwner(r, user, models.RoleViewer)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	urls, err := s.userStore.GetURLsByOwner(r.Context(), owner)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="links.%s"`, format))
+	if format == "json" {
+		if urls == nil {
+			urls = []models.URLMapping{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(urls)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	cw.Write(exportCSVHeader)
+	for _, u := range urls {
+		expiresAt := ""
+		if u.ExpiresAt != nil {
+			expiresAt = u.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		cw.Write([]string{u.Key, u.Original, u.CreatedAt, expiresAt})
+	}
+	cw.Flush()
+}
+
+// ImportLinksHandler imports links from a JSON or CSV body. Query
+// parameters: format (json or csv, default from Content-Type),
+// preserve_keys, on_conflict (skip or overwrite) and dry_run.
+func (s *Server) ImportLinksHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format == "" {
+		format = "json"
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			format = "csv"
+		}
+	}
+
+	opts, err := parseImportOptions(params.Get("preserve_keys"), params.Get("on_conflict"), params.Get("dry_run"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	owner, err := s.requestOwner(r, user, models.RoleEditor)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	opts.WorkspaceID = owner.WorkspaceID
+
+	records, err := ParseImport(http.MaxBytesReader(w, r.Body, maxImportBodyBytes), format, maxHTTPImportRecords)
+	if errors.Is(err, errTooManyRecords) {
+		http.Error(w, fmt.Sprintf("at most %d links may be imported over HTTP; use the import command for larger files", maxHTTPImportRecords), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := s.ImportLinks(r.Context(), user.ID, records, opts)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func parseImportOptions(preserveKeys, onConflict, dryRun string) (models.ImportOptions, error) {
+	opts := models.ImportOptions{OnConflict: models.ImportSkip}
+
+	var err error
+	if preserveKeys != "" {
+		if opts.PreserveKeys, err = strconv.ParseBool(preserveKeys); err != nil {
+			return opts, fmt.Errorf("preserve_keys must be true or false")
+		}
+	}
+	if dryRun != "" {
+		if opts.DryRun, err = strconv.ParseBool(dryRun); err != nil {
+			return opts, fmt.Errorf("dry_run must be true or false")
+		}
+	}
+	switch onConflict {
+	case "":
+	case models.ImportSkip, models.ImportOverwrite:
+		opts.OnConflict = onConflict
+	default:
+		return opts, fmt.Errorf("on_conflict must be 'skip' or 'overwrite'")
+	}
+	return opts, nil
+}
+
+var errTooManyRecords = errors.New("too many links to import")
+
+// ParseImport reads up to limit import records in the given format: "json"
+// for an array like the JSON export, or "csv" with a header row naming its
+// columns.
+func ParseImport(r io.Reader, format string, limit int) ([]models.ImportRecord, error) {
+	var records []models.ImportRecord
+	switch format {
+	case "json":
+		var err error
+		if records, err = parseImportJSON(r, limit); err != nil {
+			return nil, err
+		}
+	case "csv":
+		var err error
+		if records, err = parseImportCSV(r, limit); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("format must be 'json' or 'csv'")
+	}
+
+	if len(records) > limit {
+		return nil, fmt.Errorf("%w: at most %d links may be imported at once", errTooManyRecords, limit)
+	}
+	return records, nil
+}
+
+// parseImportJSON decodes the array one record at a time, stopping once it
+// holds more than limit.
+func parseImportJSON(r io.Reader, limit int) ([]models.ImportRecord, error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON")
+	}
+	if tok == nil {
+		return nil, nil
+	}
+	if tok != json.Delim('[') {
+		return nil, fmt.Errorf("invalid JSON: expected an array of links")
+	}
+
+	var records []models.ImportRecord
+	for dec.More() {
+		var record models.ImportRecord
+		if err := dec.Decode(&record); err != nil {
+			return nil, fmt.Errorf("invalid JSON")
+		}
+		records = append(records, record)
+
+		if len(records) > limit {
+			return records, nil
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("invalid JSON")
+	}
+	return records, nil
+}
+
+func parseImportCSV(r io.Reader, limit int) ([]models.ImportRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		name = strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+		columns[name] = i
+	}
+	find := func(names []string) int {
+		for _, name := range names {
+			if i, ok := columns[name]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+
+	keyCol, originalCol, expiresCol := find(importKeyColumns), find(importOriginalColumns), find(importExpiresColumns)
+	if originalCol < 0 {
+		return nil, fmt.Errorf("CSV header has no original URL column")
+	}
+
+	var records []models.ImportRecord
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid CSV on line %d: %v", line, err)
+		}
+
+		field := func(i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+
+		record := models.ImportRecord{
+			Key:      importKey(field(keyCol)),
+			Original: field(originalCol),
+		}
+		if v := field(expiresCol); v != "" {
+			expiresAt, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expires_at on line %d", line)
+			}
+			record.ExpiresAt = &expiresAt
+		}
+		records = append(records, record)
+
+		if len(records) > limit {
+			break
+		}
+	}
+	return records, nil
+}
+
+// importKey turns a short link such as "https://bit.ly/abc123" into its key.
+func importKey(v string) string {
+	v = strings.TrimRight(v, "/")
+	if i := strings.LastIndex(v, "/"); i >= 0 {
+		return v[i+1:]
+	}
+	return v
+}
+
+// ImportLinks creates or updates links for userID from records. With
+// DryRun set nothing is written, but the report shows what would happen.
+// Only failures to reach the store abort the import.
+func (s *Server) ImportLinks(ctx context.Context, userID string, records []models.ImportRecord, opts models.ImportOptions) (models.ImportReport, error) {
+	report := models.ImportReport{DryRun: opts.DryRun, Results: make([]models.ImportResult, 0, len(records))}
+
+	budget, err := s.linkBudget(ctx, importOwner(userID, opts))
+	if err != nil {
+		return report, err
+	}
+
+	originals := make([]string, len(records))
+	for i, rec := range records {
+		originals[i] = rec.Original
+	}
+	ctx = s.policy.Prefetch(ctx, originals)
+
+	// In a dry run nothing reaches the store, so earlier records of the
+	// same import are tracked here to report duplicates correctly.
+	plannedKeys := make(map[string]models.URLMapping)
+	plannedOriginals := make(map[string]string)
+
+	getKey := func(key string) (models.URLMapping, error) {
+		if m, ok := plannedKeys[key]; ok {
+			return m, nil
+		}
+		// An expired key is about to be archived, if it is not already,
+		// so it is imported as new.
+		m, err := s.urlStore.Get(ctx, key)
+		if err == nil && m.IsExpired(time.Now()) {
+			return models.URLMapping{}, store.ErrNotFound
+		}
+		return m, err
+	}
+	getOriginal := func(original string) (string, error) {
+		if key, ok := plannedOriginals[original]; ok {
+			return key, nil
+		}
+		m, err := s.urlStore.GetByOriginal(ctx, importOwner(userID, opts), original)
+		return m.Key, err
+	}
+
+	for i, rec := range records {
+		result := models.ImportResult{Index: i, Key: rec.Key, Original: rec.Original}
+		if !opts.PreserveKeys {
+			result.Key = ""
+		}
+
+		action, reason, err := s.importRecord(ctx, userID, rec, opts, &result, budget, getKey, getOriginal)
+		if errors.Is(err, store.ErrUnavailable) {
+			return report, err
+		} else if err != nil {
+			action, reason = "failed", err.Error()
+		}
+		result.Action, result.Reason = action, reason
+
+		switch action {
+		case "created", "updated":
+			if result.Key != dryRunKey {
+				plannedKeys[result.Key] = models.URLMapping{Key: result.Key, Original: rec.Original, UserID: userID, WorkspaceID: opts.WorkspaceID}
+			}
+			plannedOriginals[rec.Original] = result.Key
+			if action == "created" {
+				report.Created++
+			} else {
+				report.Updated++
+			}
+		case "skipped":
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// importOwner returns who records imported by userID with opts belong to.
+func importOwner(userID string, opts models.ImportOptions) models.LinkOwner {
+	if opts.WorkspaceID != "" {
+		return models.LinkOwner{WorkspaceID: opts.WorkspaceID}
+	}
+	return models.LinkOwner{UserID: userID}
+}
+
+func (s *Server) importRecord(ctx context.Context, userID string, rec models.ImportRecord, opts models.ImportOptions, result *models.ImportResult, budget *store.LinkBudget,
+	getKey func(string) (models.URLMapping, error), getOriginal func(string) (string, error)) (string, string, error) {
+
+	if err := s.validateShortenRequest(ctx, models.URLShortenRequest{Original: rec.Original, ExpiresAt: rec.ExpiresAt}); err != nil {
+		return "", "", err
+	}
+
+	if opts.PreserveKeys && rec.Key != "" {
+		if err := validateCustomKey(rec.Key); err != nil {
+			return "", "", err
+		}
+
+		existing, err := getKey(rec.Key)
+		if err == nil {
+			switch {
+			case existing.Owner() != importOwner(userID, opts):
+				return "", "", fmt.Errorf("key %q belongs to someone else", rec.Key)
+			case existing.Original == rec.Original:
+				return "skipped", "already exists", nil
+			case opts.OnConflict != models.ImportOverwrite:
+				return "skipped", "key already exists", nil
+			}
+			if key, err := getOriginal(rec.Original); err == nil && key != rec.Key {
+				return "skipped", fmt.Sprintf("original URL is already shortened as %q", key), nil
+			} else if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return "", "", err
+			}
+			if !opts.DryRun {
+				if err := s.urlStore.Update(ctx, rec.Key, rec.Original, rec.ExpiresAt, false, userID); err != nil {
+					return "", "", err
+				}
+			}
+			return "updated", "", nil
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return "", "", err
+		}
+	}
+
+	if key, err := getOriginal(rec.Original); err == nil {
+		result.Key = key
+		return "skipped", "original URL is already shortened", nil
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return "", "", err
+	}
+
+	mapping := models.URLMapping{
+		Key:         result.Key,
+		Original:    rec.Original,
+		UserID:      userID,
+		WorkspaceID: opts.WorkspaceID,
+		ExpiresAt:   rec.ExpiresAt,
+	}
+	if err := budget.Take(mapping.Key != ""); err != nil {
+		return "", "", err
+	}
+	if opts.DryRun {
+		if mapping.Key == "" {
+			result.Key = dryRunKey
+		}
+		return "created", "", nil
+	}
+
+	mapping, err := s.createMapping(ctx, mapping)
+	if errors.Is(err, store.ErrKeyTaken) {
+		return "", "", fmt.Errorf("key %q is already in use", mapping.Key)
+	} else if err != nil {
+		return "", "", err
+	}
+	result.Key = mapping.Key
+	return "created", "", nil
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+func TestParseImportJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    int
+		wantErr bool
+	}{
+		{"array", `[{"original_url": "https://example.com/a"}, {"original_url": "https://example.com/b", "key": "b"}]`, 2, false},
+		{"empty array", `[]`, 0, false},
+		{"null", `null`, 0, false},
+		{"object", `{"original_url": "https://example.com/a"}`, 0, true},
+		{"truncated", `[{"original_url": "https://example.com/a"}`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := ParseImport(strings.NewReader(tt.body), "json", 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if len(records) != tt.want {
+				t.Errorf("got %d records, want %d", len(records), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImportJSONStopsAtLimit(t *testing.T) {
+	// The body never ends, so the parser must stop once it is over the limit.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("["))
+		for {
+			if _, err := pw.Write([]byte(`{"original_url": "https://example.com"},`)); err != nil {
+				return
+			}
+		}
+	}()
+	defer pr.Close()
+
+	_, err := ParseImport(pr, "json", 3)
+	if !errors.Is(err, errTooManyRecords) {
+		t.Errorf("got %v, want %v", err, errTooManyRecords)
+	}
+}
+
+func TestParseImportCSVColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []models.ImportRecord
+		wantErr bool
+	}{
+		{
+			name: "export",
+			body: "key,original_url,created_at,expires_at\nabc,https://example.com/a,2026-01-01T00:00:00Z,\n",
+			want: []models.ImportRecord{{Key: "abc", Original: "https://example.com/a"}},
+		},
+		{
+			name: "bitly",
+			body: "\ufeffBitlink,Long URL,Title\nhttps://bit.ly/abc123,https://example.com/a,A\nbit.ly/def456/,https://example.com/b,B\n",
+			want: []models.ImportRecord{
+				{Key: "abc123", Original: "https://example.com/a"},
+				{Key: "def456", Original: "https://example.com/b"},
+			},
+		},
+		{
+			name: "aliases",
+			body: "Back-Half, Destination URL \nxyz,https://example.com/c\n",
+			want: []models.ImportRecord{{Key: "xyz", Original: "https://example.com/c"}},
+		},
+		{
+			name: "no key column",
+			body: "url\nhttps://example.com/d\n",
+			want: []models.ImportRecord{{Original: "https://example.com/d"}},
+		},
+		{
+			name: "short rows",
+			body: "original_url,key\nhttps://example.com/e\n",
+			want: []models.ImportRecord{{Original: "https://example.com/e"}},
+		},
+		{name: "empty", body: ""},
+		{name: "no original column", body: "key,title\nabc,A\n", wantErr: true},
+		{name: "bad expiry", body: "url,expires\nhttps://example.com,tomorrow\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := ParseImport(strings.NewReader(tt.body), "csv", 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if len(records) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", records, tt.want)
+			}
+			for i := range records {
+				if records[i].Key != tt.want[i].Key || records[i].Original != tt.want[i].Original {
+					t.Errorf("record %d: got %+v, want %+v", i, records[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseImportCSVLimit(t *testing.T) {
+	body := "url\n" + strings.Repeat("https://example.com\n", 4)
+	if _, err := ParseImport(strings.NewReader(body), "csv", 3); !errors.Is(err, errTooManyRecords) {
+		t.Errorf("got %v, want %v", err, errTooManyRecords)
+	}
+	if _, err := ParseImport(strings.NewReader(body), "csv", 4); err != nil {
+		t.Errorf("at the limit: %v", err)
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	const (
+		oldURL = "https://example.com/old"
+		newURL = "https://example.com/new"
+	)
+
+	tests := []struct {
+		name       string
+		record     models.ImportRecord
+		onConflict string
+		dryRun     bool
+		wantAction string
+		wantTarget string // of the key "taken" afterwards
+	}{
+		{"skip keeps the link", models.ImportRecord{Key: "taken", Original: newURL}, models.ImportSkip, false, "skipped", oldURL},
+		{"overwrite retargets the link", models.ImportRecord{Key: "taken", Original: newURL}, models.ImportOverwrite, false, "updated", newURL},
+		{"dry run overwrite changes nothing", models.ImportRecord{Key: "taken", Original: newURL}, models.ImportOverwrite, true, "updated", oldURL},
+		{"same target is skipped", models.ImportRecord{Key: "taken", Original: oldURL}, models.ImportOverwrite, false, "skipped", oldURL},
+		{"another user's key fails", models.ImportRecord{Key: "bobs", Original: newURL}, models.ImportOverwrite, false, "failed", oldURL},
+		{"overwrite keeps originals unique", models.ImportRecord{Key: "taken", Original: "https://example.com/other"}, models.ImportOverwrite, false, "skipped", oldURL},
+		{"new key is created", models.ImportRecord{Key: "fresh", Original: newURL}, models.ImportSkip, false, "created", oldURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, m := newTestServer(t)
+			ctx := context.Background()
+
+			alice := createTestUser(t, m, "alice")
+			bob := createTestUser(t, m, "bob")
+			createTestLink(t, m, models.URLMapping{Key: "taken", Original: oldURL, UserID: alice.ID})
+			createTestLink(t, m, models.URLMapping{Key: "other", Original: "https://example.com/other", UserID: alice.ID})
+			createTestLink(t, m, models.URLMapping{Key: "bobs", Original: oldURL, UserID: bob.ID})
+
+			opts := models.ImportOptions{PreserveKeys: true, OnConflict: tt.onConflict, DryRun: tt.dryRun}
+			report, err := s.ImportLinks(ctx, alice.ID, []models.ImportRecord{tt.record}, opts)
+			if err != nil {
+				t.Fatalf("import: %v", err)
+			}
+			if got := report.Results[0]; got.Action != tt.wantAction {
+				t.Errorf("action: got %s (%s), want %s", got.Action, got.Reason, tt.wantAction)
+			}
+
+			mapping, err := m.Get(ctx, "taken")
+			if err != nil {
+				t.Fatalf("get taken: %v", err)
+			}
+			if mapping.Original != tt.wantTarget {
+				t.Errorf("taken now points to %s, want %s", mapping.Original, tt.wantTarget)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/JamieLeeNZ/url-shortener/store"
+)
+
+const maxWorkspaceNameLength = 100
+
+func (s *Server) CreateWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxWorkspaceNameLength {
+		http.Error(w, "name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	ws, err := s.workspaces.CreateWorkspace(r.Context(), req.Name, user.ID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ws)
+}
+
+func (s *Server) ListWorkspacesHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	workspaces, err := s.workspaces.ListWorkspaces(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspaces)
+}
+
+func (s *Server) ListMembersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := r.PathValue("id")
+	if err := s.requireRole(ctx, user, id, models.RoleViewer); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	members, err := s.workspaces.ListMembers(ctx, id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// AddMemberHandler adds a user, identified by email, to the workspace. An
+// email nobody has signed in with yet gets a pending invite that is
+// accepted on their first sign-in. Only owners may add members.
+func (s *Server) AddMemberHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := r.PathValue("id")
+	if err := s.requireRole(ctx, user, id, models.RoleOwner); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var req models.AddMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+	if !models.ValidRole(req.Role) {
+		http.Error(w, "role must be owner, editor or viewer", http.StatusBadRequest)
+		return
+	}
+
+	member, err := s.workspaces.AddMember(ctx, id, req.Email, req.Role)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(member)
+}
+
+// UpdateMemberHandler changes a member's role. Only owners may change
+// roles, and the last owner cannot step down.
+func (s *Server) UpdateMemberHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, memberID := r.PathValue("id"), r.PathValue("user")
+	if err := s.requireRole(ctx, user, id, models.RoleOwner); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var req models.UpdateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !models.ValidRole(req.Role) {
+		http.Error(w, "role must be owner, editor or viewer", http.StatusBadRequest)
+		return
+	}
+
+	member, err := s.workspaces.UpdateMemberRole(ctx, id, memberID, req.Role)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "member not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(member)
+}
+
+// RemoveMemberHandler removes a member from the workspace. Owners may
+// remove anyone; other members may only remove themselves. Links the member
+// created stay with the workspace.
+func (s *Server) RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, memberID := r.PathValue("id"), r.PathValue("user")
+	role := models.RoleOwner
+	if memberID == user.ID {
+		role = models.RoleViewer
+	}
+	if err := s.requireRole(ctx, user, id, role); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	err := s.workspaces.RemoveMember(ctx, id, memberID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "member not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) RevokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := r.PathValue("id")
+	if err := s.requireRole(ctx, user, id, models.RoleOwner); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	err := s.workspaces.RevokeInvite(ctx, id, r.PathValue("email"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "invite not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
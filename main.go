@@ -1,27 +1,44 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/JamieLeeNZ/url-shortener/handlers"
+	"github.com/JamieLeeNZ/url-shortener/metrics"
 	"github.com/JamieLeeNZ/url-shortener/store"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 )
 
-func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found")
-	}
+const (
+	clickBufferSize   = 10000
+	randomKeyLength   = 6
+	redisKeyBlockSize = 100
+)
 
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL is not set")
+func newKeyGenerator(counter store.CounterSource) store.KeyGenerator {
+	switch os.Getenv("KEY_GENERATOR") {
+	case "", "random":
+		return store.NewRandomKeyGenerator(randomKeyLength)
+	case "counter":
+		return store.NewCounterKeyGenerator(counter)
+	default:
+		log.Fatalf("Unknown KEY_GENERATOR: %s", os.Getenv("KEY_GENERATOR"))
+		return nil
 	}
+}
 
+// connectRedis reads the Redis settings from the environment. If Redis
+// cannot be reached the returned breaker starts open.
+func connectRedis(ctx context.Context) (*store.RedisStore, *store.CircuitBreaker) {
 	redisAddress := os.Getenv("REDIS_ADDRESS")
 	if redisAddress == "" {
 		log.Fatal("REDIS_ADDRESS is not set")
@@ -32,65 +49,235 @@ func main() {
 		log.Fatal("REDIS_PASSWORD is not set")
 	}
 
-	oauthStateString := os.Getenv("OAUTH_STATE_STRING")
-	if oauthStateString == "" {
-		log.Fatal("OAUTH_STATE_STRING not set")
+	redisStore := store.NewRedisStore(redisAddress, redisPassword, 0, 24*time.Hour)
+	redisBreaker := store.NewCircuitBreaker("redis",
+		envInt("REDIS_BREAKER_THRESHOLD", 5),
+		envDuration("REDIS_BREAKER_COOLDOWN", 10*time.Second))
+
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := redisStore.Ping(pingCtx); err != nil {
+		log.Printf("Redis unavailable, starting in degraded mode: %v", err)
+		redisBreaker.Trip()
 	}
+	return redisStore, redisBreaker
+}
 
-	postgresStore, err := store.NewPostgresStore(dbURL)
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+func newCounterSource(postgresStore *store.PostgresStore, redisClient *redis.Client, breaker *store.CircuitBreaker) store.CounterSource {
+	if os.Getenv("KEY_COUNTER_SOURCE") == "redis" {
+		return store.NewRedisCounter(redisClient, breaker, redisKeyBlockSize)
+	}
+	return postgresStore
+}
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
 	}
-	defer postgresStore.Close()
 
-	redisStore, err := store.NewRedisStore(redisAddress, redisPassword, 0, 24*time.Hour)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImportCommand(ctx, os.Args[2:]); err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		return
+	}
+
+	cfg := loadServerConfig()
+	sweepInterval := envDuration("EXPIRY_SWEEP_INTERVAL", 10*time.Minute)
+
+	mux := http.NewServeMux()
+	handle := func(pattern, name string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, metrics.Instrument(name, h))
+	}
+
+	var s *handlers.Server
+	var urlStore store.URLStore
+	var clickStore *store.BufferedClickStore
+	var sessions store.SessionStore
+	var limiter store.RateLimiter
+
+	if os.Getenv("STORAGE_MODE") == "memory" {
+		log.Println("Using in-memory storage, data will not persist across restarts")
+
+		memoryStore := store.NewMemoryStore()
+		urlStore = memoryStore
+		clickStore = store.NewBufferedClickStore(memoryStore, clickBufferSize)
+
+		keys := newKeyGenerator(memoryStore)
+		sessions = store.NewMemorySessionStore()
+		limiter = store.NewMemoryRateLimiter()
+
+		s = handlers.NewServer(memoryStore, memoryStore, sessions, clickStore, keys, memoryStore, memoryStore, memoryStore, memoryStore)
+		store.StartExpirySweeper(ctx, memoryStore, sweepInterval)
+
+		handle("/login", "login", s.LimitLogins(s.DevLogin))
+	} else {
+		dbURL := os.Getenv("DATABASE_URL")
+		if dbURL == "" {
+			log.Fatal("DATABASE_URL is not set")
+		}
+
+		oauthStateString := os.Getenv("OAUTH_STATE_STRING")
+		if oauthStateString == "" {
+			log.Fatal("OAUTH_STATE_STRING not set")
+		}
+
+		postgresStore, err := store.NewPostgresStore(dbURL)
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+
+		redisStore, redisBreaker := connectRedis(ctx)
+
+		// The cached store owns both connections and closes them on shutdown.
+		cachedStore, err := store.NewCachedStore(redisStore, postgresStore, redisBreaker)
+		if err != nil {
+			log.Fatalf("Failed to create cached store: %v", err)
+		}
+		cachedStore.SetNegativeTTL(envDuration("NEGATIVE_CACHE_TTL", 30*time.Second))
+		if size := envInt("LOCAL_CACHE_SIZE", 0); size > 0 {
+			local := store.NewLocalCache(size, envDuration("LOCAL_CACHE_TTL", 30*time.Second))
+			cachedStore.SetLocalCache(local)
+			store.StartInvalidationListener(ctx, local, redisStore)
+		}
+		urlStore = cachedStore
+
+		redisClient := cachedStore.RedisClient()
+		if redisClient == nil {
+			log.Fatal("Redis client not available in cached store")
+		}
+
+		clickStore = store.NewBufferedClickStore(postgresStore, clickBufferSize)
+
+		keys := newKeyGenerator(newCounterSource(postgresStore, redisClient, redisBreaker))
+		sessions = store.NewFallbackSessionStore(store.NewRedisSessionStore(redisClient), redisBreaker, handlers.SessionDuration)
+		limiter = store.NewRedisRateLimiter(redisClient, redisBreaker)
+
+		s = handlers.NewServer(cachedStore, postgresStore, sessions, clickStore, keys, postgresStore, postgresStore, postgresStore, postgresStore)
+		metrics.RegisterPgxPool(postgresStore.PoolStats)
+		s.AddReadinessCheck("postgres", true, postgresStore.Ping)
+		s.AddReadinessCheck("redis", false, redisStore.Ping)
+		store.StartExpirySweeper(ctx, postgresStore, sweepInterval)
+
+		providers, err := handlers.LoadLoginProviders(ctx)
+		if err != nil {
+			log.Fatalf("Failed to configure login providers: %v", err)
+		}
+		for _, p := range providers {
+			s.AddLoginProvider(p)
+		}
+
+		handle("/login", "login", s.LimitLogins(s.Login))
+		handle("/login/{provider}", "provider_login", s.LimitLogins(s.ProviderLogin))
+		handle("/auth/{provider}/callback", "provider_callback", s.LimitLogins(s.ProviderCallback))
+	}
+
+	policy, err := handlers.LoadURLPolicy()
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		log.Fatalf("Failed to load URL policy: %v", err)
 	}
-	defer redisStore.Close()
+	s.SetURLPolicy(policy)
+	policy.StartReload(ctx, envDuration("URL_POLICY_RELOAD_INTERVAL", 30*time.Second))
 
-	cachedStore, err := store.NewCachedStore(redisStore, postgresStore)
+	limits, err := handlers.LoadRateLimits()
 	if err != nil {
-		log.Fatalf("Failed to create cached store: %v", err)
+		log.Fatalf("Failed to load rate limits: %v", err)
 	}
+	s.SetRateLimiter(limiter, limits)
 
-	redisClient := cachedStore.RedisClient()
-	if redisClient == nil {
-		log.Fatal("Redis client not available in cached store")
+	proxies, err := handlers.LoadTrustedProxies()
+	if err != nil {
+		log.Fatalf("Failed to load trusted proxies: %v", err)
 	}
+	s.SetTrustedProxies(proxies)
+
+	metrics.RegisterActiveSessions(sessions.CountSessions)
+	mux.Handle("/metrics", promhttp.Handler())
 
-	s := handlers.NewServer(cachedStore, postgresStore, redisClient)
+	handle("/health", "health", s.LivenessHandler)
+	handle("/healthz", "healthz", s.LivenessHandler)
+	handle("/readyz", "readyz", s.ReadinessHandler)
 
-	http.HandleFunc("/health", s.HealthHandler)
+	handle("/me", "me", s.RequireAuth(s.MeHandler))
+	handle("GET /me/usage", "usage", s.RequireAuth(s.UsageHandler))
+	handle("/links", "list_links", s.RequireAuth(s.ListUserLinks))
+	handle("POST /links/bulk", "bulk_create", s.RequireAuth(s.LimitBulkCreates(s.BulkCreateHandler)))
+	handle("GET /links/export", "export_links", s.RequireAuth(s.ExportLinksHandler))
+	handle("POST /links/import", "import_links", s.RequireAuth(s.LimitBulkCreates(s.ImportLinksHandler)))
+	handle("GET /links/{key}/stats", "link_stats", s.RequireAuth(s.LinkStatsHandler))
+	handle("GET /links/{key}/history", "link_history", s.RequireAuth(s.LinkHistoryHandler))
+	handle("POST /links/{key}/rollback", "link_rollback", s.RequireAuth(s.RollbackHandler))
 
-	handlers.InitOAuth()
+	handle("GET /workspaces", "list_workspaces", s.RequireAuth(s.ListWorkspacesHandler))
+	handle("POST /workspaces", "create_workspace", s.RequireAuth(s.CreateWorkspaceHandler))
+	handle("GET /workspaces/{id}/members", "list_workspace_members", s.RequireAuth(s.ListMembersHandler))
+	handle("POST /workspaces/{id}/members", "add_workspace_member", s.RequireAuth(s.AddMemberHandler))
+	handle("PATCH /workspaces/{id}/members/{user}", "update_workspace_member", s.RequireAuth(s.UpdateMemberHandler))
+	handle("DELETE /workspaces/{id}/members/{user}", "remove_workspace_member", s.RequireAuth(s.RemoveMemberHandler))
+	handle("DELETE /workspaces/{id}/invites/{email}", "revoke_workspace_invite", s.RequireAuth(s.RevokeInviteHandler))
 
-	http.HandleFunc("/login", s.GoogleLogin)
-	http.HandleFunc("/auth/google/callback", s.GoogleCallback)
+	handle("GET /tokens", "list_tokens", s.RequireAuth(s.ListTokensHandler))
+	handle("POST /tokens", "create_token", s.RequireAuth(s.CreateTokenHandler))
+	handle("DELETE /tokens/{id}", "revoke_token", s.RequireAuth(s.RevokeTokenHandler))
 
-	http.HandleFunc("/me", s.RequireAuth(s.MeHandler))
-	http.HandleFunc("/links", s.RequireAuth(s.ListUserLinks))
+	handle("/logout", "logout", s.Logout)
+	handle("GET /{key}/qr", "qr_code", s.LimitRedirects(s.QRCodeHandler))
 
-	http.HandleFunc("/logout", s.Logout)
+	create := metrics.Instrument("create", s.RequireAuth(s.LimitCreates(s.CreateHandler)))
+	redirect := metrics.Instrument("redirect", s.LimitRedirects(s.GetHandler))
+	update := metrics.Instrument("update", s.RequireAuth(s.UpdateHandler))
+	del := metrics.Instrument("delete", s.RequireAuth(s.DeleteHandler))
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
-			s.RequireAuth(s.CreateHandler)(w, r)
+			create(w, r)
 		case http.MethodGet:
-			s.GetHandler(w, r)
+			redirect(w, r)
 		case http.MethodPut:
-			s.RequireAuth(s.UpdateHandler)(w, r)
+			update(w, r)
 		case http.MethodDelete:
-			s.RequireAuth(s.DeleteHandler)(w, r)
+			del(w, r)
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 
-	port := ":8080"
-	log.Printf("Starting server at http://localhost%s/health\n", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	srv := &http.Server{
+		Addr:              cfg.addr,
+		Handler:           mux,
+		ReadTimeout:       cfg.readTimeout,
+		ReadHeaderTimeout: cfg.readHeaderTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
+		MaxHeaderBytes:    cfg.maxHeaderBytes,
+	}
+
+	go func() {
+		log.Printf("Starting server on %s\n", cfg.addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down, draining in-flight requests")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+	if err := clickStore.Close(); err != nil {
+		log.Printf("Failed to flush clicks: %v", err)
+	}
+	if err := urlStore.Close(); err != nil {
+		log.Printf("Failed to close stores: %v", err)
 	}
+	log.Println("Shutdown complete")
 }
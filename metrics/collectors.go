@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const collectTimeout = 2 * time.Second
+
+type pgxPoolCollector struct {
+	stat func() *pgxpool.Stat
+
+	acquired     *prometheus.Desc
+	idle         *prometheus.Desc
+	total        *prometheus.Desc
+	max          *prometheus.Desc
+	acquireCount *prometheus.Desc
+	acquireWait  *prometheus.Desc
+	emptyAcquire *prometheus.Desc
+}
+
+// RegisterPgxPool exposes the connection pool statistics returned by stat.
+func RegisterPgxPool(stat func() *pgxpool.Stat) {
+	prometheus.MustRegister(&pgxPoolCollector{
+		stat:         stat,
+		acquired:     prometheus.NewDesc("pgxpool_acquired_conns", "Connections currently checked out of the pool.", nil, nil),
+		idle:         prometheus.NewDesc("pgxpool_idle_conns", "Idle connections in the pool.", nil, nil),
+		total:        prometheus.NewDesc("pgxpool_total_conns", "Total connections in the pool.", nil, nil),
+		max:          prometheus.NewDesc("pgxpool_max_conns", "Maximum size of the pool.", nil, nil),
+		acquireCount: prometheus.NewDesc("pgxpool_acquire_total", "Successful connection acquisitions.", nil, nil),
+		acquireWait:  prometheus.NewDesc("pgxpool_acquire_wait_seconds_total", "Time spent waiting to acquire a connection.", nil, nil),
+		emptyAcquire: prometheus.NewDesc("pgxpool_empty_acquire_total", "Acquisitions that had to wait for a connection.", nil, nil),
+	})
+}
+
+func (c *pgxPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.total
+	ch <- c.max
+	ch <- c.acquireCount
+	ch <- c.acquireWait
+	ch <- c.emptyAcquire
+}
+
+func (c *pgxPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireWait, prometheus.CounterValue, s.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquire, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+}
+
+// RegisterActiveSessions exposes the number of live login sessions, as
+// reported by count at scrape time.
+func RegisterActiveSessions(count func(ctx context.Context) (int64, error)) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "active_sessions",
+		Help: "Login sessions that have not expired or been logged out.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+		defer cancel()
+
+		n, err := count(ctx)
+		if err != nil {
+			log.Printf("[metrics] failed to count sessions: %v", err)
+			return 0
+		}
+		return float64(n)
+	}))
+}
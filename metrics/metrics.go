@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by handler, method and status code.",
+	}, []string{"handler", "method", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by handler, method and status code.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"handler", "method", "code"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "url_cache_lookups_total",
+		Help: "URL cache lookups by lookup type and result (local_hit, hit, miss, negative, error or bypass).",
+	}, []string{"lookup", "result"})
+
+	circuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "circuit_breaker_open",
+		Help: "Whether the circuit breaker for a dependency is open (1) or closed (0).",
+	}, []string{"name"})
+)
+
+const (
+	CacheLocalHit = "local_hit"
+	CacheHit      = "hit"
+	CacheMiss     = "miss"
+	CacheNegative = "negative"
+	CacheError    = "error"
+	CacheBypass   = "bypass"
+)
+
+func CacheLookup(lookup, result string) {
+	cacheLookups.WithLabelValues(lookup, result).Inc()
+}
+
+func CircuitOpen(name string, open bool) {
+	value := 0.0
+	if open {
+		value = 1
+	}
+	circuitOpen.WithLabelValues(name).Set(value)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Instrument records the count and latency of requests served by next
+// under the given handler name.
+func Instrument(handler string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next(rec, r)
+
+		code := strconv.Itoa(rec.status)
+		httpRequests.WithLabelValues(handler, r.Method, code).Inc()
+		httpDuration.WithLabelValues(handler, r.Method, code).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+type ClickEvent struct {
+	Key       string
+	ClickedAt time.Time
+	Referrer  string
+	UserAgent string
+	IPHash    string
+}
+
+type ClickBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int64     `json:"clicks"`
+}
+
+type LinkStats struct {
+	Key         string        `json:"key"`
+	TotalClicks int64         `json:"total_clicks"`
+	Interval    string        `json:"interval"`
+	From        time.Time     `json:"from"`
+	To          time.Time     `json:"to"`
+	Buckets     []ClickBucket `json:"buckets"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// LinkRevision records one change of a link's target.
+type LinkRevision struct {
+	ID          int64     `json:"id"`
+	Key         string    `json:"key"`
+	OldOriginal string    `json:"old_original_url"`
+	NewOriginal string    `json:"new_original_url"`
+	ChangedBy   string    `json:"changed_by,omitempty"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+// RollbackRequest restores the target a link had before the given revision.
+type RollbackRequest struct {
+	RevisionID int64 `json:"revision_id"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// Plan sets the limits of a user's account. A zero limit means unlimited.
+type Plan struct {
+	Name             string `json:"name"`
+	MaxLinks         int64  `json:"max_links"`
+	MaxCustomKeys    int64  `json:"max_custom_keys"`
+	MaxMonthlyClicks int64  `json:"max_monthly_clicks"`
+}
+
+const DefaultPlan = "free"
+
+var Plans = map[string]Plan{
+	"free":     {Name: "free", MaxLinks: 100, MaxCustomKeys: 10, MaxMonthlyClicks: 10000},
+	"pro":      {Name: "pro", MaxLinks: 10000, MaxCustomKeys: 1000, MaxMonthlyClicks: 1000000},
+	"business": {Name: "business"},
+}
+
+// LookupPlan returns the named plan, or the default plan for unknown names.
+func LookupPlan(name string) Plan {
+	if plan, ok := Plans[name]; ok {
+		return plan
+	}
+	return Plans[DefaultPlan]
+}
+
+// Usage counts what a user has used of their plan. Links and CustomKeys
+// cover unexpired links; MonthlyClicks covers clicks since the start of
+// the current month.
+type Usage struct {
+	Plan          string
+	Links         int64
+	CustomKeys    int64
+	MonthlyClicks int64
+}
+
+type UsageCount struct {
+	Used int64 `json:"used"`
+	// Limit is omitted when the plan has no limit.
+	Limit int64 `json:"limit,omitempty"`
+}
+
+type UsageResponse struct {
+	Plan          string     `json:"plan"`
+	PeriodStart   time.Time  `json:"period_start"`
+	PeriodEnd     time.Time  `json:"period_end"`
+	Links         UsageCount `json:"links"`
+	CustomKeys    UsageCount `json:"custom_keys"`
+	MonthlyClicks UsageCount `json:"monthly_clicks"`
+}
@@ -1,15 +1,139 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type URLMapping struct {
-	Key       string `json:"key"`
-	Original  string `json:"original_url"`
-	CreatedAt string `json:"created_at"`
+	Key       string     `json:"key"`
+	Original  string     `json:"original_url"`
+	UserID    string     `json:"-"`
+	CreatedAt string     `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Custom marks a key chosen by the user. It counts against the plan's
+	// custom key limit and is only recorded on insert.
+	Custom bool `json:"-"`
+	// WorkspaceID is set for links owned by a workspace; UserID is then
+	// the member who created the link.
+	WorkspaceID string `json:"workspace_id,omitempty"`
+}
+
+func (m URLMapping) Owner() LinkOwner {
+	if m.WorkspaceID != "" {
+		return LinkOwner{WorkspaceID: m.WorkspaceID}
+	}
+	return LinkOwner{UserID: m.UserID}
+}
+
+func (m URLMapping) IsExpired(now time.Time) bool {
+	return m.ExpiresAt != nil && !m.ExpiresAt.After(now)
 }
 
 type URLShortenRequest struct {
-	Original string `json:"original_url"`
+	Original  string     `json:"original_url"`
+	CustomKey string     `json:"custom_key,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// ClearExpiry is set when the body has an explicit "expires_at": null.
+	// Updates use it to make a link permanent again.
+	ClearExpiry bool `json:"-"`
+}
+
+func (r *URLShortenRequest) UnmarshalJSON(data []byte) error {
+	type plain URLShortenRequest
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+
+	var raw struct {
+		ExpiresAt json.RawMessage `json:"expires_at"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	r.ClearExpiry = string(raw.ExpiresAt) == "null"
+	return nil
 }
 
 type URLShortenResponse struct {
 	Key string `json:"key"`
 }
+
+// BulkShortenResult reports the outcome of one item of a bulk request,
+// identified by its position in the input.
+type BulkShortenResult struct {
+	Index    int    `json:"index"`
+	Original string `json:"original_url"`
+	Key      string `json:"key,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type BulkShortenResponse struct {
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+	Results   []BulkShortenResult `json:"results"`
+}
+
+type LinkListQuery struct {
+	// WorkspaceID lists the workspace's links instead of the user's
+	// personal ones.
+	WorkspaceID string
+	Limit       int
+	Cursor      string
+	SortBy      string
+	Descending  bool
+	Search      string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+type LinkListItem struct {
+	URLMapping
+	Clicks int64 `json:"clicks"`
+}
+
+type LinkListPage struct {
+	Links      []LinkListItem `json:"links"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// ImportRecord is one link read from an import file.
+type ImportRecord struct {
+	Key       string     `json:"key,omitempty"`
+	Original  string     `json:"original_url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type ImportOptions struct {
+	PreserveKeys bool
+	// OnConflict is ImportSkip or ImportOverwrite and decides what happens
+	// when a preserved key already exists.
+	OnConflict string
+	DryRun     bool
+	// WorkspaceID imports into a workspace instead of the user's personal
+	// links.
+	WorkspaceID string
+}
+
+const (
+	ImportSkip      = "skip"
+	ImportOverwrite = "overwrite"
+)
+
+type ImportResult struct {
+	Index    int    `json:"index"`
+	Key      string `json:"key,omitempty"`
+	Original string `json:"original_url"`
+	// Action is created, updated, skipped or failed.
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Skipped int            `json:"skipped"`
+	Failed  int            `json:"failed"`
+	Results []ImportResult `json:"results"`
+}
@@ -9,3 +9,18 @@ type User struct {
 	Picture   string    `json:"picture,omitempty" db:"picture"`
 	CreatedAt time.Time `json:"created_at,omitempty" db:"created_at"`
 }
+
+// Identity is an account at an external login provider. A user may have
+// several, one per provider they have signed in with.
+type Identity struct {
+	Provider      string `json:"provider"`
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name,omitempty"`
+	Picture       string `json:"picture,omitempty"`
+	// TrustedEmail is set when the provider is configured as trusted to
+	// vouch for email addresses. Only then may a new identity be linked to
+	// an existing account by its verified email.
+	TrustedEmail bool `json:"-"`
+}
@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+func ValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// RoleAtLeast reports whether role grants everything want does. Owners can
+// manage members; editors can change links; viewers can only read them.
+func RoleAtLeast(role, want string) bool {
+	return ValidRole(role) && roleRanks[role] >= roleRanks[want]
+}
+
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Role is the requesting user's role in the workspace.
+	Role string `json:"role,omitempty"`
+}
+
+// WorkspaceMember is a member of a workspace, or with Pending set an invite
+// for an email nobody has signed in with yet.
+type WorkspaceMember struct {
+	UserID  string    `json:"user_id,omitempty"`
+	Email   string    `json:"email"`
+	Name    string    `json:"name,omitempty"`
+	Role    string    `json:"role"`
+	AddedAt time.Time `json:"added_at"`
+	Pending bool      `json:"pending,omitempty"`
+}
+
+type CreateWorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+type AddMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// LinkOwner is who a link belongs to: a workspace when WorkspaceID is set,
+// otherwise a single user. Each owner may shorten a destination once.
+type LinkOwner struct {
+	UserID      string
+	WorkspaceID string
+}
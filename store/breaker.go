@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/metrics"
+)
+
+// CircuitBreaker stops calls to a failing dependency after threshold
+// consecutive failures. Once cooldown has passed a single probe call is let
+// through; its outcome closes the breaker again or restarts the cooldown.
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &CircuitBreaker{name: name, threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a call may go through. Every allowed call must be
+// followed by Record.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Record feeds the result of a call back into the breaker. ErrNotFound is
+// a normal answer and counts as a success. Only ErrUnavailable, meaning the
+// dependency could not be reached or timed out, counts as a failure; a
+// cancelled caller or a bad value says nothing about its health.
+func (b *CircuitBreaker) Record(err error) {
+	switch {
+	case err == nil || errors.Is(err, ErrNotFound):
+		b.success()
+	case errors.Is(err, ErrUnavailable) && !errors.Is(err, context.Canceled):
+		b.failure()
+	default:
+		b.ignore()
+	}
+}
+
+// Trip opens the breaker straight away, e.g. when the dependency is already
+// down at startup.
+func (b *CircuitBreaker) Trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.open()
+}
+
+func (b *CircuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures >= b.threshold
+}
+
+func (b *CircuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures >= b.threshold {
+		log.Printf("[%s] circuit closed, dependency recovered", b.name)
+		metrics.CircuitOpen(b.name, false)
+	}
+	b.failures = 0
+	b.probing = false
+}
+
+// ignore ends a call without counting it, letting another probe through
+// if this one was a probe.
+func (b *CircuitBreaker) ignore() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *CircuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures+1 < b.threshold {
+		b.failures++
+		return
+	}
+	b.open()
+}
+
+func (b *CircuitBreaker) open() {
+	if b.failures < b.threshold {
+		log.Printf("[%s] circuit open, bypassing for %s", b.name, b.cooldown)
+	}
+	b.failures = b.threshold
+	b.openedAt = time.Now()
+	b.probing = false
+	metrics.CircuitOpen(b.name, true)
+}
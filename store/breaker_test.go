@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	unavailable := fmt.Errorf("%w: connection refused", ErrUnavailable)
+	cancelled := fmt.Errorf("%w: %w", ErrUnavailable, context.Canceled)
+
+	tests := []struct {
+		name     string
+		results  []error
+		wantOpen bool
+	}{
+		{"successes", []error{nil, nil, nil}, false},
+		{"below threshold", []error{unavailable, unavailable}, false},
+		{"at threshold", []error{unavailable, unavailable, unavailable}, true},
+		{"success resets count", []error{unavailable, unavailable, nil, unavailable, unavailable}, false},
+		{"not found is a success", []error{unavailable, unavailable, ErrNotFound, unavailable}, false},
+		{"cancelled calls are ignored", []error{cancelled, cancelled, cancelled}, false},
+		{"bad values are ignored", []error{errors.New("bad json"), errors.New("bad json"), errors.New("bad json")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker("test", 3, time.Hour)
+			for _, err := range tt.results {
+				if !b.Allow() {
+					t.Fatalf("breaker opened early")
+				}
+				b.Record(err)
+			}
+			if b.Open() != tt.wantOpen {
+				t.Errorf("open: got %v, want %v", b.Open(), tt.wantOpen)
+			}
+			if b.Allow() == tt.wantOpen {
+				t.Errorf("allow: got %v, want %v", !tt.wantOpen, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	unavailable := fmt.Errorf("%w: timeout", ErrUnavailable)
+
+	tests := []struct {
+		name      string
+		probe     error
+		wantOpen  bool
+		wantProbe bool // whether another probe is let through straight away
+	}{
+		{"success closes", nil, false, true},
+		{"failure reopens", unavailable, true, false},
+		{"ignored result frees the probe", errors.New("bad json"), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker("test", 1, 0)
+			b.Trip()
+			if !b.Allow() {
+				t.Fatalf("probe not allowed after cooldown")
+			}
+			if b.Allow() {
+				t.Fatalf("second call allowed while probing")
+			}
+			b.Record(tt.probe)
+
+			if b.Open() != tt.wantOpen {
+				t.Errorf("open: got %v, want %v", b.Open(), tt.wantOpen)
+			}
+			if tt.wantOpen && !tt.wantProbe {
+				// A failed probe restarts the cooldown.
+				b.cooldown = time.Hour
+			}
+			if b.Allow() != tt.wantProbe {
+				t.Errorf("allow: got %v, want %v", !tt.wantProbe, tt.wantProbe)
+			}
+		})
+	}
+}
@@ -2,22 +2,117 @@ package store
 
 import (
 	"context"
+	"errors"
 	"log"
+	"sync"
+	"time"
 
+	"github.com/JamieLeeNZ/url-shortener/metrics"
+	"github.com/JamieLeeNZ/url-shortener/models"
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
+// CachedStore reads through the cache to the database. While the breaker
+// is open the cache is bypassed entirely and everything is served from the
+// database.
 type CachedStore struct {
-	cache URLStore
-	db    URLStore
+	cache       URLStore
+	db          URLStore
+	breaker     *CircuitBreaker
+	flight      singleflight.Group
+	negativeTTL time.Duration
+	local       *LocalCache
+
+	// stale holds keys changed while the cache was unreachable. They are
+	// evicted before the cache is read again.
+	staleMu sync.Mutex
+	stale   map[string]struct{}
 }
 
 type RedisClientProvider interface {
 	RawClient() *redis.Client
 }
 
-func NewCachedStore(cache, db URLStore) (*CachedStore, error) {
-	return &CachedStore{cache: cache, db: db}, nil
+// NegativeCache is implemented by caches that can remember keys which do
+// not exist, so repeated lookups for them skip the database.
+type NegativeCache interface {
+	SetMissing(ctx context.Context, key string, ttl time.Duration) error
+}
+
+// OriginalIndex is implemented by caches that index keys by original URL,
+// so an entry can be dropped even when its key is no longer cached.
+type OriginalIndex interface {
+	DeleteOriginal(ctx context.Context, owner models.LinkOwner, original string) error
+}
+
+const defaultNegativeTTL = 30 * time.Second
+
+func NewCachedStore(cache, db URLStore, breaker *CircuitBreaker) (*CachedStore, error) {
+	return &CachedStore{
+		cache:       cache,
+		db:          db,
+		breaker:     breaker,
+		negativeTTL: defaultNegativeTTL,
+		stale:       make(map[string]struct{}),
+	}, nil
+}
+
+// SetNegativeTTL sets how long unknown keys are remembered as missing.
+// Zero disables negative caching.
+func (s *CachedStore) SetNegativeTTL(ttl time.Duration) {
+	s.negativeTTL = ttl
+}
+
+// SetLocalCache adds an in-process tier in front of the shared cache.
+// Changes are broadcast to other replicas when the cache is an Invalidator.
+func (s *CachedStore) SetLocalCache(local *LocalCache) {
+	s.local = local
+}
+
+// useCache reports whether the cache may be used. A true result must be
+// followed by breaker.Record with the outcome of the cache call.
+func (s *CachedStore) useCache(ctx context.Context) bool {
+	if !s.breaker.Allow() {
+		return false
+	}
+	if err := s.evictStale(ctx); err != nil {
+		s.breaker.Record(err)
+		return false
+	}
+	return true
+}
+
+// evictStale deletes the keys marked stale. The set is taken out under the
+// lock so other requests are not held up by the cache calls; keys that
+// could not be evicted are marked stale again.
+func (s *CachedStore) evictStale(ctx context.Context) error {
+	s.staleMu.Lock()
+	stale := s.stale
+	if len(stale) > 0 {
+		s.stale = make(map[string]struct{})
+	}
+	s.staleMu.Unlock()
+
+	for key := range stale {
+		if err := s.cache.Delete(ctx, key); err != nil && !errors.Is(err, ErrNotFound) {
+			for failed := range stale {
+				s.markStale(failed)
+			}
+			return err
+		}
+		log.Printf("[cache] evicted stale key: %s", key)
+		delete(stale, key)
+		s.publishInvalidation(ctx, key)
+	}
+	return nil
+}
+
+func (s *CachedStore) markStale(key string) {
+	s.staleMu.Lock()
+	defer s.staleMu.Unlock()
+
+	s.stale[key] = struct{}{}
 }
 
 var _ URLStore = (*CachedStore)(nil)
@@ -29,76 +124,252 @@ func (c *CachedStore) RedisClient() *redis.Client {
 	return nil
 }
 
-func (s *CachedStore) Set(ctx context.Context, key, originalURL string, userID string) error {
-	if err := s.db.Set(ctx, key, originalURL, userID); err != nil {
+func (s *CachedStore) Set(ctx context.Context, mapping models.URLMapping) error {
+	if err := s.db.Set(ctx, mapping); err != nil {
 		return err
 	}
-	return s.cache.Set(ctx, key, originalURL, userID)
+	// Caching the new mapping also replaces any negative entry for the key;
+	// if that fails the key is evicted once the cache is reachable.
+	if !s.cacheSet(ctx, mapping) {
+		s.markStale(mapping.Key)
+	}
+	return nil
+}
+
+// SetBatch inserts mappings into the database and warms the cache with the
+// ones that were created.
+func (s *CachedStore) SetBatch(ctx context.Context, mappings []models.URLMapping) ([]error, error) {
+	errs, err := s.db.SetBatch(ctx, mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	created := make([]models.URLMapping, 0, len(mappings))
+	for i, mapping := range mappings {
+		if errs[i] == nil {
+			created = append(created, mapping)
+		}
+	}
+	if len(created) == 0 {
+		return errs, nil
+	}
+
+	if s.useCache(ctx) {
+		_, err = s.cache.SetBatch(ctx, created)
+		s.breaker.Record(err)
+		if err == nil {
+			return errs, nil
+		}
+		log.Printf("[cache] failed to warm %d keys: %v", len(created), err)
+	}
+	for _, mapping := range created {
+		s.markStale(mapping.Key)
+	}
+	return errs, nil
+}
+
+func (s *CachedStore) cacheSet(ctx context.Context, mapping models.URLMapping) bool {
+	if !s.useCache(ctx) {
+		return false
+	}
+	err := s.cache.Set(ctx, mapping)
+	s.breaker.Record(err)
+	if err != nil {
+		log.Printf("[cache] failed to cache key %s: %v", mapping.Key, err)
+		return false
+	}
+	return true
 }
 
-func (s *CachedStore) GetOriginalFromKey(ctx context.Context, key string) (string, string, bool) {
-	if original, userID, found := s.cache.GetOriginalFromKey(ctx, key); found {
+func (s *CachedStore) Get(ctx context.Context, key string) (models.URLMapping, error) {
+	if mapping, ok := s.local.Get(key); ok {
+		metrics.CacheLookup("key", metrics.CacheLocalHit)
+		return mapping, nil
+	}
+
+	if !s.useCache(ctx) {
+		metrics.CacheLookup("key", metrics.CacheBypass)
+		return s.loadKey(ctx, key)
+	}
+
+	mapping, err := s.cache.Get(ctx, key)
+	s.breaker.Record(err)
+	switch {
+	case err == nil:
 		log.Printf("[cache] hit for key: %s", key)
-		return original, userID, true
+		metrics.CacheLookup("key", metrics.CacheHit)
+		s.local.Add(mapping)
+		return mapping, nil
+	case errors.Is(err, errKnownMissing):
+		metrics.CacheLookup("key", metrics.CacheNegative)
+		return models.URLMapping{}, ErrNotFound
+	case errors.Is(err, ErrNotFound):
+		log.Printf("[cache] miss for key: %s", key)
+		metrics.CacheLookup("key", metrics.CacheMiss)
+	default:
+		log.Printf("[cache] error for key %s: %v", key, err)
+		metrics.CacheLookup("key", metrics.CacheError)
 	}
-	log.Printf("[cache] miss for key: %s", key)
 
-	original, userID, found := s.db.GetOriginalFromKey(ctx, key)
-	if found {
-		log.Printf("[db] fetched and caching key: %s", key)
-		s.cache.Set(ctx, key, original, userID)
-	} else {
-		log.Printf("[db] key not found: %s", key)
+	return s.loadKey(ctx, key)
+}
+
+// loadKey reads key from the database and refreshes the cache. Concurrent
+// misses for the same key share a single query.
+func (s *CachedStore) loadKey(ctx context.Context, key string) (models.URLMapping, error) {
+	// The query is shared, so one caller going away must not cancel it
+	// for the others.
+	ctx = context.WithoutCancel(ctx)
+
+	v, err, shared := s.flight.Do(key, func() (any, error) {
+		mapping, err := s.db.Get(ctx, key)
+		if err == nil {
+			log.Printf("[db] fetched and caching key: %s", key)
+			s.local.Add(mapping)
+			s.cacheSet(ctx, mapping)
+		} else if errors.Is(err, ErrNotFound) {
+			log.Printf("[db] key not found: %s", key)
+			s.cacheMissing(ctx, key)
+		} else {
+			log.Printf("[db] error for key %s: %v", key, err)
+		}
+		return mapping, err
+	})
+	if shared {
+		log.Printf("[db] coalesced lookup for key: %s", key)
 	}
-	return original, userID, found
+	return v.(models.URLMapping), err
 }
 
-func (s *CachedStore) GetKeyFromOriginal(ctx context.Context, original string) (string, string, bool) {
-	if key, userID, found := s.cache.GetKeyFromOriginal(ctx, original); found {
+func (s *CachedStore) cacheMissing(ctx context.Context, key string) {
+	negative, ok := s.cache.(NegativeCache)
+	if !ok || s.negativeTTL <= 0 || !s.useCache(ctx) {
+		return
+	}
+	err := negative.SetMissing(ctx, key, s.negativeTTL)
+	s.breaker.Record(err)
+	if err != nil {
+		log.Printf("[cache] failed to mark key %s missing: %v", key, err)
+	}
+}
+
+func (s *CachedStore) GetByOriginal(ctx context.Context, owner models.LinkOwner, original string) (models.URLMapping, error) {
+	if !s.useCache(ctx) {
+		metrics.CacheLookup("original", metrics.CacheBypass)
+		return s.db.GetByOriginal(ctx, owner, original)
+	}
+
+	mapping, err := s.cache.GetByOriginal(ctx, owner, original)
+	s.breaker.Record(err)
+	if err == nil {
 		log.Printf("[cache] hit for original URL: %s", original)
-		return key, userID, true
+		metrics.CacheLookup("original", metrics.CacheHit)
+		return mapping, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("[cache] miss for original URL: %s", original)
+		metrics.CacheLookup("original", metrics.CacheMiss)
+	} else {
+		log.Printf("[cache] error for original URL %s: %v", original, err)
+		metrics.CacheLookup("original", metrics.CacheError)
 	}
-	log.Printf("[cache] miss for original URL: %s", original)
 
-	key, userID, found := s.db.GetKeyFromOriginal(ctx, original)
-	if found {
+	mapping, err = s.db.GetByOriginal(ctx, owner, original)
+	if err == nil {
 		log.Printf("[db] fetched and caching original URL: %s", original)
-		s.cache.Set(ctx, key, original, userID)
-	} else {
+		s.cacheSet(ctx, mapping)
+	} else if errors.Is(err, ErrNotFound) {
 		log.Printf("[db] original URL not found: %s", original)
+	} else {
+		log.Printf("[db] error for original URL %s: %v", original, err)
 	}
-	return key, userID, found
+	return mapping, err
 }
 
-func (s *CachedStore) ContainsKey(ctx context.Context, key string) bool {
-	if exists := s.cache.ContainsKey(ctx, key); exists {
-		return true
+func (s *CachedStore) ContainsKey(ctx context.Context, key string) (bool, error) {
+	if s.useCache(ctx) {
+		exists, err := s.cache.ContainsKey(ctx, key)
+		s.breaker.Record(err)
+		if err == nil && exists {
+			return true, nil
+		}
 	}
 	return s.db.ContainsKey(ctx, key)
 }
 
-func (s *CachedStore) Update(ctx context.Context, key, newValue string) bool {
-	ok := s.db.Update(ctx, key, newValue)
-	if ok {
-		s.cache.Update(ctx, key, newValue)
+func (s *CachedStore) Update(ctx context.Context, key, newValue string, expiresAt *time.Time, clearExpiry bool, editedBy string) error {
+	previous, err := s.db.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Update(ctx, key, newValue, expiresAt, clearExpiry, editedBy); err != nil {
+		return err
+	}
+	s.cacheInvalidate(ctx, key, func() error {
+		err := s.cache.Update(ctx, key, newValue, expiresAt, clearExpiry, editedBy)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		// The key may not be cached while its original URL still is.
+		if index, ok := s.cache.(OriginalIndex); ok && previous.Original != newValue {
+			return index.DeleteOriginal(ctx, previous.Owner(), previous.Original)
+		}
+		return err
+	})
+	return nil
+}
+
+func (s *CachedStore) Delete(ctx context.Context, key string) error {
+	if err := s.db.Delete(ctx, key); err != nil {
+		return err
 	}
-	return ok
+	s.cacheInvalidate(ctx, key, func() error {
+		return s.cache.Delete(ctx, key)
+	})
+	return nil
 }
 
-func (s *CachedStore) Delete(ctx context.Context, key string) bool {
-	ok := s.db.Delete(ctx, key)
-	if ok {
-		s.cache.Delete(ctx, key)
+// cacheInvalidate applies a write to the cached copy of key. If the cache
+// cannot be reached the key is remembered and evicted once it is back.
+func (s *CachedStore) cacheInvalidate(ctx context.Context, key string, write func() error) {
+	s.local.Remove(key)
+
+	if !s.useCache(ctx) {
+		s.markStale(key)
+		return
+	}
+	err := write()
+	s.breaker.Record(err)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("[cache] failed to update key %s: %v", key, err)
+		s.markStale(key)
+		return
 	}
-	return ok
+	s.publishInvalidation(ctx, key)
 }
 
+// publishInvalidation tells other replicas to drop key from their local
+// cache. If it cannot be sent their copies expire with the local TTL.
+func (s *CachedStore) publishInvalidation(ctx context.Context, key string) {
+	invalidator, ok := s.cache.(Invalidator)
+	if s.local == nil || !ok {
+		return
+	}
+	err := invalidator.PublishInvalidation(ctx, key)
+	s.breaker.Record(err)
+	if err != nil {
+		log.Printf("[cache] failed to publish invalidation for key %s: %v", key, err)
+	}
+}
+
+// Close shuts the cache down before the database, so nothing can be
+// re-cached from a database that is going away.
 func (c *CachedStore) Close() error {
-	errDB := c.db.Close()
 	errCache := c.cache.Close()
+	errDB := c.db.Close()
 
-	if errDB != nil {
-		return errDB
+	if errCache != nil {
+		return errCache
 	}
-	return errCache
+	return errDB
 }
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+// fakeCache is a MemoryStore that can fail deletes and remember missing
+// keys, standing in for Redis.
+type fakeCache struct {
+	*MemoryStore
+	failDelete error
+	deleted    []string
+	missing    map[string]bool
+	originals  []string
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{MemoryStore: NewMemoryStore(), missing: make(map[string]bool)}
+}
+
+func (c *fakeCache) Get(ctx context.Context, key string) (models.URLMapping, error) {
+	if c.missing[key] {
+		return models.URLMapping{}, errKnownMissing
+	}
+	return c.MemoryStore.Get(ctx, key)
+}
+
+func (c *fakeCache) Set(ctx context.Context, mapping models.URLMapping) error {
+	delete(c.missing, mapping.Key)
+	return c.MemoryStore.Set(ctx, mapping)
+}
+
+func (c *fakeCache) Delete(ctx context.Context, key string) error {
+	if c.failDelete != nil {
+		return c.failDelete
+	}
+	c.deleted = append(c.deleted, key)
+	return c.MemoryStore.Delete(ctx, key)
+}
+
+func (c *fakeCache) SetMissing(ctx context.Context, key string, ttl time.Duration) error {
+	c.missing[key] = true
+	return nil
+}
+
+func (c *fakeCache) DeleteOriginal(ctx context.Context, owner models.LinkOwner, original string) error {
+	c.originals = append(c.originals, original)
+	return nil
+}
+
+// countingStore counts database reads.
+type countingStore struct {
+	*MemoryStore
+	gets int
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) (models.URLMapping, error) {
+	s.gets++
+	return s.MemoryStore.Get(ctx, key)
+}
+
+func newTestCachedStore(t *testing.T) (*CachedStore, *fakeCache, *countingStore) {
+	t.Helper()
+
+	cache, db := newFakeCache(), &countingStore{MemoryStore: NewMemoryStore()}
+	s, err := NewCachedStore(cache, db, NewCircuitBreaker("test", 3, time.Hour))
+	if err != nil {
+		t.Fatalf("new cached store: %v", err)
+	}
+	return s, cache, db
+}
+
+func TestEvictStale(t *testing.T) {
+	ctx := context.Background()
+	s, cache, _ := newTestCachedStore(t)
+
+	s.markStale("a")
+	s.markStale("b")
+
+	cache.failDelete = fmt.Errorf("%w: connection refused", ErrUnavailable)
+	if err := s.evictStale(ctx); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("got %v, want %v", err, ErrUnavailable)
+	}
+	if len(s.stale) != 2 {
+		t.Errorf("failed evictions were dropped: %d keys still stale, want 2", len(s.stale))
+	}
+
+	cache.failDelete = nil
+	if err := s.evictStale(ctx); err != nil {
+		t.Fatalf("evict: %v", err)
+	}
+	if len(s.stale) != 0 || len(cache.deleted) != 2 {
+		t.Errorf("got %d keys still stale and %v deleted, want none stale and 2 deleted", len(s.stale), cache.deleted)
+	}
+}
+
+func TestStaleKeysAreEvictedBeforeTheCacheIsRead(t *testing.T) {
+	ctx := context.Background()
+	s, cache, _ := newTestCachedStore(t)
+
+	if err := s.Set(ctx, models.URLMapping{Key: "a", Original: "https://example.com/a"}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	// The delete misses the cache, which keeps serving the old mapping
+	// until the key is evicted.
+	cache.failDelete = fmt.Errorf("%w: connection refused", ErrUnavailable)
+	if err := s.Delete(ctx, "a"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	cache.failDelete = nil
+
+	if _, err := s.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		negativeTTL time.Duration
+		wantDBGets  int
+	}{
+		{"enabled", time.Minute, 1},
+		{"disabled", 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, _, db := newTestCachedStore(t)
+			s.SetNegativeTTL(tt.negativeTTL)
+
+			for i := 0; i < 3; i++ {
+				if _, err := s.Get(ctx, "nope"); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("get %d: got %v, want %v", i, err, ErrNotFound)
+				}
+			}
+			if db.gets != tt.wantDBGets {
+				t.Errorf("got %d database reads, want %d", db.gets, tt.wantDBGets)
+			}
+		})
+	}
+}
+
+func TestCreatingAKeyReplacesItsNegativeEntry(t *testing.T) {
+	ctx := context.Background()
+	s, _, _ := newTestCachedStore(t)
+
+	if _, err := s.Get(ctx, "later"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Set(ctx, models.URLMapping{Key: "later", Original: "https://example.com"}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if _, err := s.Get(ctx, "later"); err != nil {
+		t.Errorf("key still reported missing: %v", err)
+	}
+}
+
+func TestUpdateDropsThePreviousOriginalFromTheCache(t *testing.T) {
+	ctx := context.Background()
+	s, cache, db := newTestCachedStore(t)
+
+	// The link is only in the database, as after a cache restart.
+	if err := db.Set(ctx, models.URLMapping{Key: "a", Original: "https://example.com/old"}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := s.Update(ctx, "a", "https://example.com/new", nil, false, ""); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if len(cache.originals) != 1 || cache.originals[0] != "https://example.com/old" {
+		t.Errorf("got %v dropped, want the old original", cache.originals)
+	}
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+type ClickStore interface {
+	RecordClicks(ctx context.Context, events []models.ClickEvent) error
+	// GetClickStats counts all clicks for key and buckets those in [from, to)
+	// by interval, which is either "hour" or "day".
+	GetClickStats(ctx context.Context, key, interval string, from, to time.Time) (models.LinkStats, error)
+}
+
+const (
+	clickBatchSize     = 100
+	clickFlushInterval = time.Second
+)
+
+// BufferedClickStore queues clicks in memory and writes them to the
+// underlying store in batches, so recording never blocks a redirect.
+type BufferedClickStore struct {
+	next   ClickStore
+	events chan models.ClickEvent
+	done   chan struct{}
+}
+
+var _ ClickStore = (*BufferedClickStore)(nil)
+
+func NewBufferedClickStore(next ClickStore, bufferSize int) *BufferedClickStore {
+	b := &BufferedClickStore{
+		next:   next,
+		events: make(chan models.ClickEvent, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *BufferedClickStore) RecordClicks(ctx context.Context, events []models.ClickEvent) error {
+	for _, e := range events {
+		select {
+		case b.events <- e:
+		default:
+			log.Printf("[clicks] buffer full, dropping click for key: %s", e.Key)
+		}
+	}
+	return nil
+}
+
+func (b *BufferedClickStore) GetClickStats(ctx context.Context, key, interval string, from, to time.Time) (models.LinkStats, error) {
+	return b.next.GetClickStats(ctx, key, interval, from, to)
+}
+
+// Close stops accepting clicks and flushes whatever is still queued.
+func (b *BufferedClickStore) Close() error {
+	close(b.events)
+	<-b.done
+	return nil
+}
+
+func (b *BufferedClickStore) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(clickFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.ClickEvent, 0, clickBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := b.next.RecordClicks(context.Background(), batch); err != nil {
+			log.Printf("[clicks] failed to record %d clicks: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case e, ok := <-b.events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= clickBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
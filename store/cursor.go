@@ -0,0 +1,85 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// listCursor points just past the last item of a page: the value of the
+// sort column and the key, which breaks ties.
+type listCursor struct {
+	Value string `json:"v"`
+	Key   string `json:"k"`
+}
+
+func encodeCursor(value, key string) string {
+	data, _ := json.Marshal(listCursor{Value: value, Key: key})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return listCursor{}, ErrInvalidCursor
+	}
+
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Key == "" {
+		return listCursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// listRow is a link list item with the values it can be sorted by.
+type listRow struct {
+	item      models.LinkListItem
+	createdAt time.Time
+}
+
+func (r listRow) sortValue(sortBy string) string {
+	switch sortBy {
+	case SortByCreated:
+		return r.createdAt.UTC().Format(time.RFC3339Nano)
+	case SortByClicks:
+		return strconv.FormatInt(r.item.Clicks, 10)
+	default:
+		return r.item.Key
+	}
+}
+
+// sortArg is the sort value as a query argument.
+func (r listRow) sortArg(sortBy string) any {
+	switch sortBy {
+	case SortByCreated:
+		return r.createdAt
+	case SortByClicks:
+		return r.item.Clicks
+	default:
+		return r.item.Key
+	}
+}
+
+// cursorRow turns c back into the row it points past, rejecting values
+// that do not parse for sortBy.
+func cursorRow(sortBy string, c listCursor) (listRow, error) {
+	row := listRow{item: models.LinkListItem{URLMapping: models.URLMapping{Key: c.Key}}}
+
+	var err error
+	switch sortBy {
+	case SortByCreated:
+		row.createdAt, err = time.Parse(time.RFC3339Nano, c.Value)
+	case SortByClicks:
+		row.item.Clicks, err = strconv.ParseInt(c.Value, 10, 64)
+	}
+	if err != nil {
+		return listRow{}, ErrInvalidCursor
+	}
+	return row, nil
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2026, 3, 4, 5, 6, 7, 891011121, time.FixedZone("NZDT", 13*60*60))
+	row := listRow{
+		item:      models.LinkListItem{URLMapping: models.URLMapping{Key: "abc"}, Clicks: 42},
+		createdAt: createdAt,
+	}
+
+	for _, sortBy := range []string{SortByCreated, SortByClicks, SortByKey} {
+		t.Run(sortBy, func(t *testing.T) {
+			c, err := decodeCursor(encodeCursor(row.sortValue(sortBy), row.item.Key))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			got, err := cursorRow(sortBy, c)
+			if err != nil {
+				t.Fatalf("cursor row: %v", err)
+			}
+			if got.item.Key != "abc" {
+				t.Errorf("key: got %q, want %q", got.item.Key, "abc")
+			}
+			if compareListRows(sortBy, got, row) != 0 {
+				t.Errorf("row does not compare equal: got %+v, want %+v", got, row)
+			}
+		})
+	}
+}
+
+func TestInvalidCursors(t *testing.T) {
+	tests := []struct {
+		name, sortBy, cursor string
+	}{
+		{"not base64", SortByKey, "!!!"},
+		{"not json", SortByKey, "bm90IGpzb24"},
+		{"no key", SortByKey, encodeCursor("abc", "")},
+		{"bad time", SortByCreated, encodeCursor("yesterday", "abc")},
+		{"bad count", SortByClicks, encodeCursor("many", "abc")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := decodeCursor(tt.cursor)
+			if err == nil {
+				_, err = cursorRow(tt.sortBy, c)
+			}
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("got %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
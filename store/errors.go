@@ -0,0 +1,88 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrUnavailable = errors.New("store unavailable")
+	ErrKeyTaken    = fmt.Errorf("%w: key already taken", ErrConflict)
+	ErrEmailInUse  = fmt.Errorf("%w: email belongs to another account", ErrConflict)
+
+	// ErrQuotaExceeded is returned when creating links would go over the
+	// owner's plan.
+	ErrQuotaExceeded = errors.New("plan limit reached")
+
+	// errKnownMissing is returned by the cache for keys it has recorded as
+	// not existing, so callers can skip the database.
+	errKnownMissing = fmt.Errorf("%w: cached as missing", ErrNotFound)
+)
+
+const (
+	pgUniqueViolation = "23505"
+	urlMappingsPKey   = "url_mappings_pkey"
+)
+
+// conflictMessages describes unique violations to clients. The server's
+// detail names the conflicting row, which may belong to someone else, so it
+// is only logged.
+var conflictMessages = map[string]string{
+	"idx_url_mappings_user_original":      "original URL is already shortened",
+	"idx_url_mappings_workspace_original": "original URL is already shortened",
+	"workspace_members_pkey":              "user is already a member",
+	"users_email_key":                     "email is already in use",
+}
+
+// pgErr maps a pgx error onto the store's error kinds. Errors reported by
+// the server itself are passed through, anything else means we could not
+// talk to Postgres.
+func pgErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	var pgError *pgconn.PgError
+	if errors.As(err, &pgError) {
+		if pgError.Code == pgUniqueViolation && pgError.ConstraintName == urlMappingsPKey {
+			return ErrKeyTaken
+		}
+		if pgError.Code == pgUniqueViolation {
+			log.Printf("[db] unique violation on %s: %s", pgError.ConstraintName, pgError.Detail)
+			msg, ok := conflictMessages[pgError.ConstraintName]
+			if !ok {
+				msg = "already exists"
+			}
+			return fmt.Errorf("%w: %s", ErrConflict, msg)
+		}
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
+
+// redisErr maps a go-redis error onto the store's error kinds. Error replies
+// mean Redis answered and are passed through; anything else means it could
+// not be reached in time.
+func redisErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, redis.Nil) {
+		return ErrNotFound
+	}
+	var reply redis.Error
+	if errors.As(err, &reply) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
@@ -0,0 +1,17 @@
+package store
+
+import (
+	"context"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+// HistoryStore reads the edit history recorded by URLStore.Update. History
+// outlives the link, so it survives deletion and expiry. A key can be
+// reused once freed; only revisions made since the current or most
+// recently archived link under key was created are returned.
+type HistoryStore interface {
+	// ListLinkHistory returns the revisions of key, newest first.
+	ListLinkHistory(ctx context.Context, key string) ([]models.LinkRevision, error)
+	GetLinkRevision(ctx context.Context, key string, id int64) (models.LinkRevision, error)
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+)
+
+const base62Charset = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// counterKeyOffset makes the first counter-based key six characters long
+// (62^5) rather than handing out "0", "1", "2", ...
+const counterKeyOffset = 916132832
+
+type KeyGenerator interface {
+	NextKey(ctx context.Context) (string, error)
+}
+
+// CounterSource hands out blocks of unique counter values. A block is the
+// half-open range [start, start+size).
+type CounterSource interface {
+	ReserveBlock(ctx context.Context) (start, size int64, err error)
+}
+
+type RandomKeyGenerator struct {
+	length int
+}
+
+func NewRandomKeyGenerator(length int) *RandomKeyGenerator {
+	return &RandomKeyGenerator{length: length}
+}
+
+func (g *RandomKeyGenerator) NextKey(ctx context.Context) (string, error) {
+	b := make([]byte, g.length)
+	for i := range b {
+		b[i] = base62Charset[rand.Intn(len(base62Charset))]
+	}
+	return string(b), nil
+}
+
+type CounterKeyGenerator struct {
+	source CounterSource
+
+	mu   sync.Mutex
+	next int64
+	end  int64
+}
+
+func NewCounterKeyGenerator(source CounterSource) *CounterKeyGenerator {
+	return &CounterKeyGenerator{source: source}
+}
+
+func (g *CounterKeyGenerator) NextKey(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.next >= g.end {
+		start, size, err := g.source.ReserveBlock(ctx)
+		if err != nil {
+			return "", err
+		}
+		g.next, g.end = start, start+size
+	}
+
+	n := g.next
+	g.next++
+	return EncodeBase62(counterKeyOffset + n), nil
+}
+
+func EncodeBase62(n int64) string {
+	if n == 0 {
+		return string(base62Charset[0])
+	}
+
+	var b []byte
+	for n > 0 {
+		b = append(b, base62Charset[n%62])
+		n /= 62
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
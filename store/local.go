@@ -0,0 +1,116 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+// LocalCache is a bounded in-process LRU of mappings that sits in front of
+// the shared cache. Entries live for at most ttl, which also bounds how
+// stale a replica can be if it misses an invalidation message. A nil
+// *LocalCache is valid and caches nothing.
+type LocalCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type localEntry struct {
+	mapping   models.URLMapping
+	expiresAt time.Time
+}
+
+func NewLocalCache(size int, ttl time.Duration) *LocalCache {
+	return &LocalCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *LocalCache) Get(key string) (models.URLMapping, bool) {
+	if c == nil {
+		return models.URLMapping{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return models.URLMapping{}, false
+	}
+	entry := elem.Value.(*localEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return models.URLMapping{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.mapping, true
+}
+
+func (c *LocalCache) Add(mapping models.URLMapping) {
+	if c == nil {
+		return
+	}
+
+	expiresAt := time.Now().Add(c.ttl)
+	if mapping.ExpiresAt != nil && mapping.ExpiresAt.Before(expiresAt) {
+		expiresAt = *mapping.ExpiresAt
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[mapping.Key]; ok {
+		elem.Value = &localEntry{mapping: mapping, expiresAt: expiresAt}
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[mapping.Key] = c.order.PushFront(&localEntry{mapping: mapping, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*localEntry).mapping.Key)
+	}
+}
+
+func (c *LocalCache) Remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// Invalidator broadcasts changed keys to every replica's local cache.
+type Invalidator interface {
+	PublishInvalidation(ctx context.Context, key string) error
+	SubscribeInvalidations(ctx context.Context) <-chan string
+}
+
+// StartInvalidationListener removes keys from the local cache as other
+// replicas report them changed, until ctx is cancelled.
+func StartInvalidationListener(ctx context.Context, cache *LocalCache, source Invalidator) {
+	go func() {
+		for key := range source.SubscribeInvalidations(ctx) {
+			cache.Remove(key)
+		}
+		log.Println("[local] invalidation listener stopped")
+	}()
+}
@@ -0,0 +1,848 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/google/uuid"
+)
+
+type memoryURL struct {
+	original    string
+	userID      string
+	workspaceID string
+	createdAt   time.Time
+	expiresAt   *time.Time
+	custom      bool
+}
+
+func (u memoryURL) toMapping(key string) models.URLMapping {
+	return models.URLMapping{
+		Key:         key,
+		Original:    u.original,
+		UserID:      u.userID,
+		WorkspaceID: u.workspaceID,
+		CreatedAt:   u.createdAt.Format(time.RFC3339),
+		ExpiresAt:   u.expiresAt,
+	}
+}
+
+func (u memoryURL) isExpired(now time.Time) bool {
+	return u.expiresAt != nil && !u.expiresAt.After(now)
+}
+
+// ownedURL identifies a destination within one owner's links; each owner
+// may shorten a destination once.
+type ownedURL struct {
+	owner    models.LinkOwner
+	original string
+}
+
+func (u memoryURL) owner() models.LinkOwner {
+	return models.URLMapping{UserID: u.userID, WorkspaceID: u.workspaceID}.Owner()
+}
+
+func (u memoryURL) owned() ownedURL {
+	return ownedURL{u.owner(), u.original}
+}
+
+type MemoryStore struct {
+	mu         sync.RWMutex
+	urls       map[string]memoryURL
+	archive    map[string]memoryURL
+	originals  map[ownedURL]string
+	users      map[string]models.User
+	clicks     map[string][]models.ClickEvent
+	tokens     map[string]*memoryToken
+	identities map[string]string
+	history    map[string][]models.LinkRevision
+	workspaces map[string]models.Workspace
+	members    map[string]map[string]models.WorkspaceMember
+	invites    map[string]map[string]models.WorkspaceMember
+	counter    int64
+	revisionID int64
+}
+
+type memoryToken struct {
+	token   models.APIToken
+	revoked bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		urls:       make(map[string]memoryURL),
+		archive:    make(map[string]memoryURL),
+		originals:  make(map[ownedURL]string),
+		users:      make(map[string]models.User),
+		clicks:     make(map[string][]models.ClickEvent),
+		tokens:     make(map[string]*memoryToken),
+		identities: make(map[string]string),
+		history:    make(map[string][]models.LinkRevision),
+		workspaces: make(map[string]models.Workspace),
+		members:    make(map[string]map[string]models.WorkspaceMember),
+		invites:    make(map[string]map[string]models.WorkspaceMember),
+	}
+}
+
+var _ URLStore = (*MemoryStore)(nil)
+var _ UserStore = (*MemoryStore)(nil)
+var _ ExpiredArchiver = (*MemoryStore)(nil)
+var _ ClickStore = (*MemoryStore)(nil)
+var _ CounterSource = (*MemoryStore)(nil)
+var _ HistoryStore = (*MemoryStore)(nil)
+var _ TokenStore = (*MemoryStore)(nil)
+var _ QuotaStore = (*MemoryStore)(nil)
+var _ WorkspaceStore = (*MemoryStore)(nil)
+
+const memoryKeyBlockSize = 100
+
+// releaseExpiredOriginal drops an expired mapping still holding owned.
+// Callers must hold m.mu for writing.
+func (m *MemoryStore) releaseExpiredOriginal(owned ownedURL) {
+	key, ok := m.originals[owned]
+	if ok && m.urls[key].isExpired(time.Now()) {
+		m.archiveKey(key)
+	}
+}
+
+// archiveKey moves key out of the live mappings. Callers must hold m.mu
+// for writing.
+func (m *MemoryStore) archiveKey(key string) {
+	u := m.urls[key]
+	m.archive[key] = u
+	delete(m.urls, key)
+	delete(m.originals, u.owned())
+}
+
+func (m *MemoryStore) Set(ctx context.Context, mapping models.URLMapping) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.reserveLinks([]models.URLMapping{mapping})[0]; err != nil {
+		return err
+	}
+	return m.set(mapping)
+}
+
+func (m *MemoryStore) SetBatch(ctx context.Context, mappings []models.URLMapping) ([]error, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := m.reserveLinks(mappings)
+	for i, mapping := range mappings {
+		if errs[i] == nil {
+			errs[i] = m.set(mapping)
+		}
+	}
+	return errs, nil
+}
+
+// reserveLinks takes each personal mapping from its creator's plan,
+// returning an error wrapping ErrQuotaExceeded for those over it. Callers
+// must hold m.mu for writing.
+func (m *MemoryStore) reserveLinks(mappings []models.URLMapping) []error {
+	budgets := make(map[string]*LinkBudget)
+	errs := make([]error, len(mappings))
+	for i, mapping := range mappings {
+		if mapping.UserID == "" || mapping.WorkspaceID != "" {
+			continue
+		}
+		budget, ok := budgets[mapping.UserID]
+		if !ok {
+			budget = NewLinkBudget(m.linkUsage(mapping.UserID, time.Now()))
+			budgets[mapping.UserID] = budget
+		}
+		errs[i] = budget.Take(mapping.Custom)
+	}
+	return errs
+}
+
+// linkUsage counts userID's unexpired personal links on the default plan.
+// Callers must hold m.mu.
+func (m *MemoryStore) linkUsage(userID string, now time.Time) models.Usage {
+	usage := models.Usage{Plan: models.DefaultPlan}
+	for _, u := range m.urls {
+		if u.userID != userID || u.workspaceID != "" || u.isExpired(now) {
+			continue
+		}
+		usage.Links++
+		if u.custom {
+			usage.CustomKeys++
+		}
+	}
+	return usage
+}
+
+// set inserts mapping. Callers must hold m.mu for writing.
+func (m *MemoryStore) set(mapping models.URLMapping) error {
+	owned := ownedURL{mapping.Owner(), mapping.Original}
+	m.releaseExpiredOriginal(owned)
+	if existingKey, ok := m.originals[owned]; ok && existingKey != mapping.Key {
+		return fmt.Errorf("%w: original URL already mapped to key %s", ErrConflict, existingKey)
+	}
+
+	if _, ok := m.urls[mapping.Key]; ok {
+		return ErrKeyTaken
+	}
+
+	m.urls[mapping.Key] = memoryURL{
+		original:    mapping.Original,
+		userID:      mapping.UserID,
+		workspaceID: mapping.WorkspaceID,
+		createdAt:   time.Now(),
+		expiresAt:   mapping.ExpiresAt,
+		custom:      mapping.Custom,
+	}
+	m.originals[owned] = mapping.Key
+	return nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) (models.URLMapping, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.urls[key]
+	if !ok {
+		u, ok = m.archive[key]
+	}
+	if !ok {
+		return models.URLMapping{}, ErrNotFound
+	}
+	return u.toMapping(key), nil
+}
+
+func (m *MemoryStore) GetByOriginal(ctx context.Context, owner models.LinkOwner, original string) (models.URLMapping, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.originals[ownedURL{owner, original}]
+	if !ok || m.urls[key].isExpired(time.Now()) {
+		return models.URLMapping{}, ErrNotFound
+	}
+	return m.urls[key].toMapping(key), nil
+}
+
+func (m *MemoryStore) ContainsKey(ctx context.Context, key string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.urls[key]
+	return ok, nil
+}
+
+func (m *MemoryStore) Update(ctx context.Context, key, newValue string, expiresAt *time.Time, clearExpiry bool, editedBy string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.urls[key]
+	if !ok {
+		return ErrNotFound
+	}
+	owned := ownedURL{u.owner(), newValue}
+	m.releaseExpiredOriginal(owned)
+	if existingKey, taken := m.originals[owned]; taken && existingKey != key {
+		return fmt.Errorf("%w: original URL already mapped to key %s", ErrConflict, existingKey)
+	}
+
+	if newValue != u.original {
+		m.revisionID++
+		m.history[key] = append(m.history[key], models.LinkRevision{
+			ID:          m.revisionID,
+			Key:         key,
+			OldOriginal: u.original,
+			NewOriginal: newValue,
+			ChangedBy:   editedBy,
+			ChangedAt:   time.Now().UTC(),
+		})
+	}
+
+	delete(m.originals, u.owned())
+	u.original = newValue
+	if clearExpiry {
+		u.expiresAt = nil
+	} else if expiresAt != nil {
+		u.expiresAt = expiresAt
+	}
+	m.urls[key] = u
+	m.originals[owned] = key
+	return nil
+}
+
+func (m *MemoryStore) ListLinkHistory(ctx context.Context, key string) ([]models.LinkRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	history := m.currentHistory(key)
+	revisions := make([]models.LinkRevision, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		revisions = append(revisions, history[i])
+	}
+	return revisions, nil
+}
+
+// currentHistory returns the revisions of the link now at key, or of the
+// one most recently archived there, oldest first. Callers must hold m.mu.
+func (m *MemoryStore) currentHistory(key string) []models.LinkRevision {
+	u, ok := m.urls[key]
+	if !ok {
+		u = m.archive[key]
+	}
+
+	history := m.history[key]
+	for i, rev := range history {
+		if !rev.ChangedAt.Before(u.createdAt) {
+			return history[i:]
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetLinkRevision(ctx context.Context, key string, id int64) (models.LinkRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, rev := range m.currentHistory(key) {
+		if rev.ID == id {
+			return rev, nil
+		}
+	}
+	return models.LinkRevision{}, ErrNotFound
+}
+
+// GetUsage reports every user as being on the default plan; plans are only
+// stored in Postgres.
+func (m *MemoryStore) GetUsage(ctx context.Context, userID string, clicksSince time.Time) (models.Usage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	usage := m.linkUsage(userID, now)
+	for key, u := range m.urls {
+		if u.userID != userID || u.workspaceID != "" || u.isExpired(now) {
+			continue
+		}
+		for _, e := range m.linkClicks(key, u) {
+			if !e.ClickedAt.Before(clicksSince) {
+				usage.MonthlyClicks++
+			}
+		}
+	}
+	return usage, nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.urls[key]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.urls, key)
+	delete(m.originals, u.owned())
+	return nil
+}
+
+func (m *MemoryStore) ArchiveExpired(ctx context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for key, u := range m.urls {
+		if u.isExpired(now) {
+			m.archiveKey(key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) ReserveBlock(ctx context.Context) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := m.counter
+	m.counter += memoryKeyBlockSize
+	return start, memoryKeyBlockSize, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func (m *MemoryStore) GetOrCreateUser(ctx context.Context, user models.User) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.users[user.ID]; ok {
+		return existing, nil
+	}
+
+	user.CreatedAt = time.Now()
+	m.users[user.ID] = user
+	return user, nil
+}
+
+func (m *MemoryStore) GetURLsByOwner(ctx context.Context, owner models.LinkOwner) ([]models.URLMapping, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var owned []string
+	for key, u := range m.urls {
+		if u.owner() == owner {
+			owned = append(owned, key)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return m.urls[owned[i]].createdAt.After(m.urls[owned[j]].createdAt)
+	})
+
+	var urls []models.URLMapping
+	for _, key := range owned {
+		urls = append(urls, m.urls[key].toMapping(key))
+	}
+	return urls, nil
+}
+
+func (m *MemoryStore) RecordClicks(ctx context.Context, events []models.ClickEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range events {
+		m.clicks[e.Key] = append(m.clicks[e.Key], e)
+	}
+	return nil
+}
+
+// linkClicks returns the clicks recorded at key since u was created there,
+// leaving out those made on an earlier link at the same key. Callers must
+// hold m.mu.
+func (m *MemoryStore) linkClicks(key string, u memoryURL) []models.ClickEvent {
+	var clicks []models.ClickEvent
+	for _, e := range m.clicks[key] {
+		if !e.ClickedAt.Before(u.createdAt) {
+			clicks = append(clicks, e)
+		}
+	}
+	return clicks
+}
+
+func (m *MemoryStore) GetClickStats(ctx context.Context, key, interval string, from, to time.Time) (models.LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	truncate := 24 * time.Hour
+	if interval == "hour" {
+		truncate = time.Hour
+	}
+
+	u, ok := m.urls[key]
+	if !ok {
+		u = m.archive[key]
+	}
+	events := m.linkClicks(key, u)
+	counts := make(map[time.Time]int64)
+	for _, e := range events {
+		if e.ClickedAt.Before(from) || !e.ClickedAt.Before(to) {
+			continue
+		}
+		counts[e.ClickedAt.UTC().Truncate(truncate)]++
+	}
+
+	stats := models.LinkStats{
+		Key:         key,
+		TotalClicks: int64(len(events)),
+		Interval:    interval,
+		From:        from,
+		To:          to,
+		Buckets:     []models.ClickBucket{},
+	}
+	for start, n := range counts {
+		stats.Buckets = append(stats.Buckets, models.ClickBucket{Start: start, Clicks: n})
+	}
+	sort.Slice(stats.Buckets, func(i, j int) bool {
+		return stats.Buckets[i].Start.Before(stats.Buckets[j].Start)
+	})
+	return stats, nil
+}
+
+func compareListRows(sortBy string, a, b listRow) int {
+	var c int
+	switch sortBy {
+	case SortByCreated:
+		c = a.createdAt.Compare(b.createdAt)
+	case SortByClicks:
+		c = cmp.Compare(a.item.Clicks, b.item.Clicks)
+	}
+	if c == 0 {
+		c = strings.Compare(a.item.Key, b.item.Key)
+	}
+	return c
+}
+
+func (m *MemoryStore) ListURLsByUserID(ctx context.Context, userID string, query models.LinkListQuery) (models.LinkListPage, error) {
+	if _, ok := listSortColumns[query.SortBy]; !ok {
+		return models.LinkListPage{}, fmt.Errorf("unknown sort column: %s", query.SortBy)
+	}
+
+	var after *listRow
+	if query.Cursor != "" {
+		c, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return models.LinkListPage{}, err
+		}
+		row, err := cursorRow(query.SortBy, c)
+		if err != nil {
+			return models.LinkListPage{}, err
+		}
+		after = &row
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	search := strings.ToLower(query.Search)
+	var rows []listRow
+	owner := models.LinkOwner{UserID: userID}
+	if query.WorkspaceID != "" {
+		owner = models.LinkOwner{WorkspaceID: query.WorkspaceID}
+	}
+	for key, u := range m.urls {
+		if u.owner() != owner {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(u.original), search) {
+			continue
+		}
+		if query.CreatedFrom != nil && u.createdAt.Before(*query.CreatedFrom) {
+			continue
+		}
+		if query.CreatedTo != nil && !u.createdAt.Before(*query.CreatedTo) {
+			continue
+		}
+
+		row := listRow{
+			item: models.LinkListItem{
+				URLMapping: u.toMapping(key),
+				Clicks:     int64(len(m.linkClicks(key, u))),
+			},
+			createdAt: u.createdAt,
+		}
+		if after != nil {
+			c := compareListRows(query.SortBy, row, *after)
+			if (query.Descending && c >= 0) || (!query.Descending && c <= 0) {
+				continue
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		c := compareListRows(query.SortBy, rows[i], rows[j])
+		if query.Descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	page := models.LinkListPage{Links: []models.LinkListItem{}}
+	for i, row := range rows {
+		if i == query.Limit {
+			last := rows[i-1]
+			page.NextCursor = encodeCursor(last.sortValue(query.SortBy), last.item.Key)
+			break
+		}
+		page.Links = append(page.Links, row.item)
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) CreateToken(ctx context.Context, token models.APIToken, tokenHash string) (models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tokens[tokenHash]; ok {
+		return models.APIToken{}, ErrConflict
+	}
+
+	token.CreatedAt = time.Now()
+	m.tokens[tokenHash] = &memoryToken{token: token}
+	return token, nil
+}
+
+func (m *MemoryStore) ListTokens(ctx context.Context, userID string) ([]models.APIToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tokens := []models.APIToken{}
+	for _, t := range m.tokens {
+		if t.token.UserID == userID && !t.revoked {
+			tokens = append(tokens, t.token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+func (m *MemoryStore) RevokeToken(ctx context.Context, userID, tokenID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tokens {
+		if t.token.ID == tokenID && t.token.UserID == userID && !t.revoked {
+			t.revoked = true
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *MemoryStore) AuthenticateToken(ctx context.Context, tokenHash string) (models.User, models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	t, ok := m.tokens[tokenHash]
+	if !ok || t.revoked || (t.token.ExpiresAt != nil && !t.token.ExpiresAt.After(now)) {
+		return models.User{}, models.APIToken{}, ErrNotFound
+	}
+
+	user, ok := m.users[t.token.UserID]
+	if !ok {
+		return models.User{}, models.APIToken{}, ErrNotFound
+	}
+
+	t.token.LastUsedAt = &now
+	return user, t.token, nil
+}
+
+func identityKey(identity models.Identity) string {
+	return identity.Provider + "|" + identity.Subject
+}
+
+func (m *MemoryStore) GetOrCreateUserByIdentity(ctx context.Context, identity models.Identity) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if userID, ok := m.identities[identityKey(identity)]; ok {
+		return m.users[userID], nil
+	}
+
+	var user models.User
+	found := false
+	for _, u := range m.users {
+		if u.Email == identity.Email {
+			user, found = u, true
+			break
+		}
+	}
+	if found && !(identity.EmailVerified && identity.TrustedEmail) {
+		return models.User{}, ErrEmailInUse
+	}
+	if !found {
+		user = models.User{
+			ID:        uuid.New().String(),
+			Email:     identity.Email,
+			Name:      identity.Name,
+			Picture:   identity.Picture,
+			CreatedAt: time.Now(),
+		}
+		m.users[user.ID] = user
+	}
+
+	m.identities[identityKey(identity)] = user.ID
+	return user, nil
+}
+
+func (m *MemoryStore) LinkIdentity(ctx context.Context, userID string, identity models.Identity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if linkedTo, ok := m.identities[identityKey(identity)]; ok {
+		if linkedTo != userID {
+			return fmt.Errorf("%w: identity is linked to another user", ErrConflict)
+		}
+		return nil
+	}
+	m.identities[identityKey(identity)] = userID
+	return nil
+}
+
+func (m *MemoryStore) CreateWorkspace(ctx context.Context, name, ownerID string) (models.Workspace, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ws := models.Workspace{ID: uuid.New().String(), Name: name, CreatedAt: time.Now().UTC()}
+	m.workspaces[ws.ID] = ws
+
+	owner := m.users[ownerID]
+	m.members[ws.ID] = map[string]models.WorkspaceMember{
+		ownerID: {UserID: ownerID, Email: owner.Email, Name: owner.Name, Role: models.RoleOwner, AddedAt: ws.CreatedAt},
+	}
+
+	ws.Role = models.RoleOwner
+	return ws, nil
+}
+
+func (m *MemoryStore) ListWorkspaces(ctx context.Context, userID string) ([]models.Workspace, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	workspaces := []models.Workspace{}
+	for id, members := range m.members {
+		if member, ok := members[userID]; ok {
+			ws := m.workspaces[id]
+			ws.Role = member.Role
+			workspaces = append(workspaces, ws)
+		}
+	}
+	sort.Slice(workspaces, func(i, j int) bool {
+		return workspaces[i].CreatedAt.Before(workspaces[j].CreatedAt)
+	})
+	return workspaces, nil
+}
+
+func (m *MemoryStore) GetMemberRole(ctx context.Context, workspaceID, userID string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	member, ok := m.members[workspaceID][userID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return member.Role, nil
+}
+
+func (m *MemoryStore) ListMembers(ctx context.Context, workspaceID string) ([]models.WorkspaceMember, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	members := []models.WorkspaceMember{}
+	for _, member := range m.members[workspaceID] {
+		members = append(members, member)
+	}
+	for _, invite := range m.invites[workspaceID] {
+		members = append(members, invite)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Pending != members[j].Pending {
+			return !members[i].Pending
+		}
+		return members[i].AddedAt.Before(members[j].AddedAt)
+	})
+	return members, nil
+}
+
+func (m *MemoryStore) AddMember(ctx context.Context, workspaceID, email, role string) (models.WorkspaceMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.workspaces[workspaceID]; !ok {
+		return models.WorkspaceMember{}, ErrNotFound
+	}
+	var user *models.User
+	for _, u := range m.users {
+		if u.Email == email || (user == nil && strings.EqualFold(u.Email, email)) {
+			user = &u
+		}
+	}
+	if user != nil {
+		if _, ok := m.members[workspaceID][user.ID]; ok {
+			return models.WorkspaceMember{}, fmt.Errorf("%w: user is already a member", ErrConflict)
+		}
+		member := models.WorkspaceMember{UserID: user.ID, Email: user.Email, Name: user.Name, Role: role, AddedAt: time.Now().UTC()}
+		m.members[workspaceID][user.ID] = member
+		return member, nil
+	}
+
+	invite := models.WorkspaceMember{Email: strings.ToLower(email), Role: role, AddedAt: time.Now().UTC(), Pending: true}
+	if m.invites[workspaceID] == nil {
+		m.invites[workspaceID] = make(map[string]models.WorkspaceMember)
+	}
+	m.invites[workspaceID][invite.Email] = invite
+	return invite, nil
+}
+
+func (m *MemoryStore) AcceptInvites(ctx context.Context, userID, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	email = strings.ToLower(email)
+	user := m.users[userID]
+	for workspaceID, invites := range m.invites {
+		invite, ok := invites[email]
+		if !ok {
+			continue
+		}
+		delete(invites, email)
+		if _, ok := m.members[workspaceID][userID]; ok {
+			continue
+		}
+		m.members[workspaceID][userID] = models.WorkspaceMember{UserID: userID, Email: user.Email, Name: user.Name, Role: invite.Role, AddedAt: time.Now().UTC()}
+	}
+	return nil
+}
+
+func (m *MemoryStore) UpdateMemberRole(ctx context.Context, workspaceID, userID, role string) (models.WorkspaceMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := m.members[workspaceID]
+	member, ok := members[userID]
+	if !ok {
+		return models.WorkspaceMember{}, ErrNotFound
+	}
+	if member.Role == models.RoleOwner && role != models.RoleOwner && countOwners(members) <= 1 {
+		return models.WorkspaceMember{}, fmt.Errorf("%w: a workspace must keep at least one owner", ErrConflict)
+	}
+
+	member.Role = role
+	members[userID] = member
+	return member, nil
+}
+
+func (m *MemoryStore) RemoveMember(ctx context.Context, workspaceID, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := m.members[workspaceID]
+	member, ok := members[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	if member.Role == models.RoleOwner && countOwners(members) <= 1 {
+		return fmt.Errorf("%w: a workspace must keep at least one owner", ErrConflict)
+	}
+	delete(members, userID)
+	return nil
+}
+
+func (m *MemoryStore) RevokeInvite(ctx context.Context, workspaceID, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	email = strings.ToLower(email)
+	if _, ok := m.invites[workspaceID][email]; !ok {
+		return ErrNotFound
+	}
+	delete(m.invites[workspaceID], email)
+	return nil
+}
+
+func countOwners(members map[string]models.WorkspaceMember) int {
+	owners := 0
+	for _, member := range members {
+		if member.Role == models.RoleOwner {
+			owners++
+		}
+	}
+	return owners
+}
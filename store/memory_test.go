@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+func TestReusedKeyStartsWithNoClicks(t *testing.T) {
+	m := NewMemoryStore()
+	ctx := context.Background()
+
+	user, err := m.GetOrCreateUser(ctx, models.User{ID: "alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	link := models.URLMapping{Key: "reused", Original: "https://example.com/old", UserID: user.ID}
+	if err := m.Set(ctx, link); err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+	clickedAt := time.Now().Add(-time.Second)
+	if err := m.RecordClicks(ctx, []models.ClickEvent{{Key: "reused", ClickedAt: clickedAt}, {Key: "reused", ClickedAt: clickedAt}}); err != nil {
+		t.Fatalf("record clicks: %v", err)
+	}
+	if err := m.Delete(ctx, "reused"); err != nil {
+		t.Fatalf("delete link: %v", err)
+	}
+
+	link.Original = "https://example.com/new"
+	if err := m.Set(ctx, link); err != nil {
+		t.Fatalf("recreate link: %v", err)
+	}
+	if err := m.RecordClicks(ctx, []models.ClickEvent{{Key: "reused", ClickedAt: time.Now()}}); err != nil {
+		t.Fatalf("record clicks: %v", err)
+	}
+
+	now := time.Now()
+	stats, err := m.GetClickStats(ctx, "reused", "day", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("get stats: %v", err)
+	}
+	var bucketed int64
+	for _, b := range stats.Buckets {
+		bucketed += b.Clicks
+	}
+	if stats.TotalClicks != 1 || bucketed != 1 {
+		t.Errorf("stats: got %d total, %d bucketed, want 1 each", stats.TotalClicks, bucketed)
+	}
+
+	page, err := m.ListURLsByUserID(ctx, user.ID, models.LinkListQuery{Limit: 10, SortBy: SortByCreated})
+	if err != nil {
+		t.Fatalf("list links: %v", err)
+	}
+	if len(page.Links) != 1 || page.Links[0].Clicks != 1 {
+		t.Errorf("list: got %+v, want one link with 1 click", page.Links)
+	}
+
+	usage, err := m.GetUsage(ctx, user.ID, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("get usage: %v", err)
+	}
+	if usage.MonthlyClicks != 1 {
+		t.Errorf("usage: got %d monthly clicks, want 1", usage.MonthlyClicks)
+	}
+}
+
+func TestAddMemberMatchesEmailCaseInsensitively(t *testing.T) {
+	m := NewMemoryStore()
+	ctx := context.Background()
+
+	if _, err := m.GetOrCreateUser(ctx, models.User{ID: "bob", Email: "Bob@Example.com"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	ws, err := m.CreateWorkspace(ctx, "team", "owner")
+	if err != nil {
+		t.Fatalf("create workspace: %v", err)
+	}
+
+	member, err := m.AddMember(ctx, ws.ID, "bob@example.COM", models.RoleEditor)
+	if err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if member.Pending || member.UserID != "bob" {
+		t.Errorf("got %+v, want bob added as a member", member)
+	}
+}
+
+func TestUpdateRecordsRevisionOnlyWhenDestinationChanges(t *testing.T) {
+	m := NewMemoryStore()
+	ctx := context.Background()
+
+	if err := m.Set(ctx, models.URLMapping{Key: "link", Original: "https://example.com/a"}); err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	updates := []struct {
+		original  string
+		expiresAt *time.Time
+	}{
+		{"https://example.com/a", nil},
+		{"https://example.com/a", &expiresAt},
+		{"https://example.com/b", nil},
+		{"https://example.com/b", nil},
+	}
+	for _, u := range updates {
+		if err := m.Update(ctx, "link", u.original, u.expiresAt, false, "alice"); err != nil {
+			t.Fatalf("update to %s: %v", u.original, err)
+		}
+	}
+
+	history, err := m.ListLinkHistory(ctx, "link")
+	if err != nil {
+		t.Fatalf("list history: %v", err)
+	}
+	if len(history) != 1 || history[0].NewOriginal != "https://example.com/b" {
+		t.Errorf("got %+v, want a single revision to /b", history)
+	}
+}
+
+func TestReusedKeyStartsWithNoHistory(t *testing.T) {
+	m := NewMemoryStore()
+	ctx := context.Background()
+
+	link := models.URLMapping{Key: "reused", Original: "https://example.com/a"}
+	if err := m.Set(ctx, link); err != nil {
+		t.Fatalf("create link: %v", err)
+	}
+	if err := m.Update(ctx, "reused", "https://example.com/b", nil, false, "alice"); err != nil {
+		t.Fatalf("update link: %v", err)
+	}
+	if err := m.Delete(ctx, "reused"); err != nil {
+		t.Fatalf("delete link: %v", err)
+	}
+	if err := m.Set(ctx, link); err != nil {
+		t.Fatalf("recreate link: %v", err)
+	}
+
+	history, err := m.ListLinkHistory(ctx, "reused")
+	if err != nil {
+		t.Fatalf("list history: %v", err)
+	}
+	if len(history) != 0 {
+		t.Errorf("got %+v, want no revisions from the deleted link", history)
+	}
+	if _, err := m.GetLinkRevision(ctx, "reused", 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+}
@@ -2,9 +2,14 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -35,61 +40,399 @@ func NewPostgresStore(connString string) (*PostgresStore, error) {
 
 var _ URLStore = (*PostgresStore)(nil)
 var _ UserStore = (*PostgresStore)(nil)
+var _ ExpiredArchiver = (*PostgresStore)(nil)
+var _ ClickStore = (*PostgresStore)(nil)
+var _ CounterSource = (*PostgresStore)(nil)
+var _ TokenStore = (*PostgresStore)(nil)
+var _ HistoryStore = (*PostgresStore)(nil)
+var _ QuotaStore = (*PostgresStore)(nil)
+var _ WorkspaceStore = (*PostgresStore)(nil)
 
-func (s *PostgresStore) Set(ctx context.Context, key, originalURL string, userID string) error {
-	_, err := s.db.Exec(ctx, `
-		INSERT INTO url_mappings (key, original_url, user_id) VALUES ($1, $2, $3)
-		ON CONFLICT (key) DO UPDATE SET original_url = EXCLUDED.original_url
-	`, key, originalURL, userID)
-	return err
+const postgresKeyBlockSize = 100
+
+func (s *PostgresStore) Set(ctx context.Context, mapping models.URLMapping) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return pgErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := archiveExpiredOriginal(ctx, tx, mapping.Owner(), mapping.Original); err != nil {
+		return err
+	}
+	errs, err := reserveLinks(ctx, tx, []models.URLMapping{mapping})
+	if err != nil {
+		return err
+	}
+	if errs[0] != nil {
+		return errs[0]
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO url_mappings (key, original_url, user_id, expires_at, custom_key, workspace_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+	`, mapping.Key, mapping.Original, mapping.UserID, mapping.ExpiresAt, mapping.Custom, mapping.WorkspaceID)
+	if err != nil {
+		return pgErr(err)
+	}
+	return pgErr(tx.Commit(ctx))
 }
 
-func (s *PostgresStore) GetOriginalFromKey(ctx context.Context, key string) (string, string, bool) {
-	var original, userID string
-	err := s.db.QueryRow(ctx,
-		`SELECT original_url, user_id FROM url_mappings WHERE key = $1`, key).Scan(&original, &userID)
+func (s *PostgresStore) SetBatch(ctx context.Context, mappings []models.URLMapping) ([]error, error) {
+	keys := make([]string, len(mappings))
+	originals := make([]string, len(mappings))
+	userIDs := make([]string, len(mappings))
+	expiries := make([]*time.Time, len(mappings))
+	custom := make([]bool, len(mappings))
+	workspaceIDs := make([]string, len(mappings))
+	owners := make([]models.LinkOwner, len(mappings))
+	for i, m := range mappings {
+		keys[i], originals[i], userIDs[i], expiries[i], custom[i] = m.Key, m.Original, m.UserID, m.ExpiresAt, m.Custom
+		workspaceIDs[i], owners[i] = m.WorkspaceID, m.Owner()
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, pgErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := archiveExpiredOriginals(ctx, tx, owners, originals); err != nil {
+		return nil, err
+	}
+	errs, err := reserveLinks(ctx, tx, mappings)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make([]bool, len(mappings))
+	for i := range mappings {
+		allowed[i] = errs[i] == nil
+	}
+
+	// Rows that clash on key or on the owner's original URL, including with
+	// each other, are skipped. The outer query runs against the snapshot from
+	// before the insert, so EXISTS reports keys that were already taken.
+	rows, err := tx.Query(ctx, `
+		WITH input AS (
+			SELECT * FROM unnest($1::text[], $2::text[], $3::text[], $4::timestamptz[], $5::boolean[], $6::text[], $7::boolean[])
+				WITH ORDINALITY AS t(k, o, u, e, c, w, a, n)
+		), inserted AS (
+			INSERT INTO url_mappings (key, original_url, user_id, expires_at, custom_key, workspace_id)
+			SELECT k, o, u, e, c, NULLIF(w, '') FROM input WHERE a ORDER BY n
+			ON CONFLICT DO NOTHING
+			RETURNING key, original_url
+		)
+		SELECT i.n, ins.key IS NOT NULL, EXISTS (SELECT 1 FROM url_mappings m WHERE m.key = i.k)
+		FROM input i
+		LEFT JOIN inserted ins ON ins.key = i.k AND ins.original_url = i.o
+		ORDER BY i.n`, keys, originals, userIDs, expiries, custom, workspaceIDs, allowed)
+	if err != nil {
+		return nil, pgErr(err)
+	}
+
+	claimed := make(map[string]bool, len(mappings))
+	var n int
+	var done, keyExists bool
+	_, err = pgx.ForEachRow(rows, []any{&n, &done, &keyExists}, func() error {
+		key := mappings[n-1].Key
+		switch {
+		case !allowed[n-1]:
+			// Already failed the quota check.
+		case done && !claimed[key]:
+			claimed[key] = true
+		case done || claimed[key] || keyExists:
+			errs[n-1] = ErrKeyTaken
+		default:
+			errs[n-1] = fmt.Errorf("%w: original URL is already shortened", ErrConflict)
+		}
+		return nil
+	})
 	if err != nil {
-		return "", "", false
+		return nil, pgErr(err)
 	}
-	return original, userID, true
+	if err := tx.Commit(ctx); err != nil {
+		return nil, pgErr(err)
+	}
+	return errs, nil
 }
 
-func (s *PostgresStore) GetKeyFromOriginal(ctx context.Context, original string) (string, string, bool) {
-	var key, userID string
-	err := s.db.QueryRow(ctx,
-		`SELECT key, user_id FROM url_mappings WHERE original_url = $1`, original).Scan(&key, &userID)
+func (s *PostgresStore) Get(ctx context.Context, key string) (models.URLMapping, error) {
+	// A live mapping wins over an archived one; the key may have been
+	// reused since it was archived.
+	m := models.URLMapping{Key: key}
+	err := s.db.QueryRow(ctx, `
+		SELECT original_url, COALESCE(user_id, ''), COALESCE(workspace_id, ''), created_at, expires_at
+		FROM (
+			SELECT original_url, user_id, workspace_id, created_at, expires_at, false AS archived
+			FROM url_mappings WHERE key = $1
+			UNION ALL
+			(SELECT original_url, user_id, workspace_id, created_at, expires_at, true
+			 FROM url_mappings_archive WHERE key = $1
+			 ORDER BY archived_at DESC LIMIT 1)
+		) m
+		ORDER BY archived LIMIT 1`, key,
+	).Scan(&m.Original, &m.UserID, &m.WorkspaceID, &m.CreatedAt, &m.ExpiresAt)
 	if err != nil {
-		return "", "", false
+		return models.URLMapping{}, pgErr(err)
 	}
-	return key, userID, true
+	return m, nil
 }
 
-func (s *PostgresStore) ContainsKey(ctx context.Context, key string) bool {
+func (s *PostgresStore) GetByOriginal(ctx context.Context, owner models.LinkOwner, original string) (models.URLMapping, error) {
+	m := models.URLMapping{Original: original, WorkspaceID: owner.WorkspaceID}
+	var args queryArgs
+	err := s.db.QueryRow(ctx, `
+		SELECT key, COALESCE(user_id, ''), created_at, expires_at
+		FROM url_mappings m
+		WHERE `+ownerCondition(owner, &args)+` AND original_url = `+args.add(original)+`
+		  AND (expires_at IS NULL OR expires_at > now())`,
+		args...,
+	).Scan(&m.Key, &m.UserID, &m.CreatedAt, &m.ExpiresAt)
+	if err != nil {
+		return models.URLMapping{}, pgErr(err)
+	}
+	return m, nil
+}
+
+func (s *PostgresStore) ContainsKey(ctx context.Context, key string) (bool, error) {
 	var exists bool
 	err := s.db.QueryRow(ctx,
 		`SELECT EXISTS(SELECT 1 FROM url_mappings WHERE key = $1)`, key).Scan(&exists)
 	if err != nil {
-		return false
+		return false, pgErr(err)
 	}
-	return exists
+	return exists, nil
 }
 
-func (s *PostgresStore) Update(ctx context.Context, key, newValue string) bool {
-	cmdTag, err := s.db.Exec(ctx,
-		`UPDATE url_mappings SET original_url = $1 WHERE key = $2`, newValue, key)
+func (s *PostgresStore) Update(ctx context.Context, key, newValue string, expiresAt *time.Time, clearExpiry bool, editedBy string) error {
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		return false
+		return pgErr(err)
 	}
-	return cmdTag.RowsAffected() > 0
+	defer tx.Rollback(ctx)
+
+	current := models.URLMapping{Key: key}
+	err = tx.QueryRow(ctx, `
+		SELECT original_url, COALESCE(user_id, ''), COALESCE(workspace_id, '')
+		FROM url_mappings WHERE key = $1 FOR UPDATE`, key).Scan(&current.Original, &current.UserID, &current.WorkspaceID)
+	if err != nil {
+		return pgErr(err)
+	}
+	oldValue := current.Original
+
+	if err := archiveExpiredOriginal(ctx, tx, current.Owner(), newValue); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE url_mappings
+		SET original_url = $1, expires_at = CASE WHEN $4 THEN NULL ELSE COALESCE($3, expires_at) END
+		WHERE key = $2`, newValue, key, expiresAt, clearExpiry)
+	if err != nil {
+		return pgErr(err)
+	}
+
+	// History only tracks the destination, so an expiry change or a no-op
+	// edit leaves no revision.
+	if newValue == oldValue {
+		return pgErr(tx.Commit(ctx))
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO url_mapping_history (key, old_original_url, new_original_url, changed_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''))`, key, oldValue, newValue, editedBy)
+	if err != nil {
+		return pgErr(err)
+	}
+	return pgErr(tx.Commit(ctx))
 }
 
-func (s *PostgresStore) Delete(ctx context.Context, key string) bool {
+// linkCreatedAt is when the link at key $1 was created: the live one, or
+// else the most recently archived one.
+const linkCreatedAt = `COALESCE(
+		(SELECT created_at FROM url_mappings WHERE key = $1),
+		(SELECT created_at FROM url_mappings_archive WHERE key = $1 ORDER BY archived_at DESC LIMIT 1),
+		'-infinity')`
+
+func (s *PostgresStore) ListLinkHistory(ctx context.Context, key string) ([]models.LinkRevision, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, key, old_original_url, new_original_url, COALESCE(changed_by, ''), changed_at
+		FROM url_mapping_history
+		WHERE key = $1 AND changed_at >= `+linkCreatedAt+`
+		ORDER BY id DESC`, key)
+	if err != nil {
+		return nil, pgErr(err)
+	}
+	defer rows.Close()
+
+	revisions := []models.LinkRevision{}
+	for rows.Next() {
+		var rev models.LinkRevision
+		if err := rows.Scan(&rev.ID, &rev.Key, &rev.OldOriginal, &rev.NewOriginal, &rev.ChangedBy, &rev.ChangedAt); err != nil {
+			return nil, pgErr(err)
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, pgErr(rows.Err())
+}
+
+func (s *PostgresStore) GetLinkRevision(ctx context.Context, key string, id int64) (models.LinkRevision, error) {
+	var rev models.LinkRevision
+	err := s.db.QueryRow(ctx, `
+		SELECT id, key, old_original_url, new_original_url, COALESCE(changed_by, ''), changed_at
+		FROM url_mapping_history
+		WHERE key = $1 AND id = $2 AND changed_at >= `+linkCreatedAt, key, id).
+		Scan(&rev.ID, &rev.Key, &rev.OldOriginal, &rev.NewOriginal, &rev.ChangedBy, &rev.ChangedAt)
+	return rev, pgErr(err)
+}
+
+func (s *PostgresStore) GetUsage(ctx context.Context, userID string, clicksSince time.Time) (models.Usage, error) {
+	var usage models.Usage
+	err := s.db.QueryRow(ctx, `
+		SELECT u.plan, l.links, l.custom_keys,
+		       (SELECT count(*) FROM link_clicks c JOIN url_mappings m ON m.key = c.key
+		        WHERE m.user_id = u.id AND m.workspace_id IS NULL
+		          AND c.clicked_at >= $2 AND c.clicked_at >= m.created_at)
+		FROM users u,
+		     LATERAL (SELECT count(*) AS links, count(*) FILTER (WHERE custom_key) AS custom_keys
+		              FROM url_mappings
+		              WHERE user_id = u.id AND workspace_id IS NULL
+		                AND (expires_at IS NULL OR expires_at > now())) l
+		WHERE u.id = $1`, userID, clicksSince).
+		Scan(&usage.Plan, &usage.Links, &usage.CustomKeys, &usage.MonthlyClicks)
+	return usage, pgErr(err)
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, key string) error {
 	cmdTag, err := s.db.Exec(ctx,
 		`DELETE FROM url_mappings WHERE key = $1`, key)
 	if err != nil {
-		return false
+		return pgErr(err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return ErrNotFound
 	}
-	return cmdTag.RowsAffected() > 0
+	return nil
+}
+
+func (s *PostgresStore) ArchiveExpired(ctx context.Context, now time.Time) (int64, error) {
+	cmdTag, err := s.db.Exec(ctx, `
+		WITH expired AS (
+			DELETE FROM url_mappings WHERE expires_at <= $1
+			RETURNING key, original_url, user_id, workspace_id, created_at, expires_at
+		)
+		INSERT INTO url_mappings_archive (key, original_url, user_id, workspace_id, created_at, expires_at)
+		SELECT key, original_url, user_id, workspace_id, created_at, expires_at FROM expired`, now)
+	if err != nil {
+		return 0, pgErr(err)
+	}
+	return cmdTag.RowsAffected(), nil
+}
+
+// queryArgs collects the arguments of a query as it is built.
+type queryArgs []any
+
+// add appends v and returns its placeholder.
+func (a *queryArgs) add(v any) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// ownerCondition matches url_mappings rows, aliased m, that belong to
+// owner. Each kind of owner gets plain predicates so the planner can use
+// that kind's partial indexes.
+func ownerCondition(owner models.LinkOwner, args *queryArgs) string {
+	if owner.WorkspaceID != "" {
+		return "m.workspace_id = " + args.add(owner.WorkspaceID)
+	}
+	return "m.user_id = " + args.add(owner.UserID) + " AND m.workspace_id IS NULL"
+}
+
+// reserveLinks takes each personal mapping from its creator's plan,
+// returning an error wrapping ErrQuotaExceeded for those over it. The
+// creators' user rows stay locked until tx ends, so concurrent inserts
+// cannot both pass the check.
+func reserveLinks(ctx context.Context, tx pgx.Tx, mappings []models.URLMapping) ([]error, error) {
+	var userIDs []string
+	budgets := make(map[string]*LinkBudget)
+	for _, m := range mappings {
+		if _, ok := budgets[m.UserID]; !ok && m.UserID != "" && m.WorkspaceID == "" {
+			budgets[m.UserID] = nil
+			userIDs = append(userIDs, m.UserID)
+		}
+	}
+	// Lock in a fixed order so two batches cannot deadlock.
+	sort.Strings(userIDs)
+
+	for _, id := range userIDs {
+		var usage models.Usage
+		if err := tx.QueryRow(ctx, `SELECT plan FROM users WHERE id = $1 FOR UPDATE`, id).Scan(&usage.Plan); err != nil {
+			return nil, pgErr(err)
+		}
+		err := tx.QueryRow(ctx, `
+			SELECT count(*), count(*) FILTER (WHERE custom_key)
+			FROM url_mappings
+			WHERE user_id = $1 AND workspace_id IS NULL
+			  AND (expires_at IS NULL OR expires_at > now())`, id).
+			Scan(&usage.Links, &usage.CustomKeys)
+		if err != nil {
+			return nil, pgErr(err)
+		}
+		budgets[id] = NewLinkBudget(usage)
+	}
+
+	errs := make([]error, len(mappings))
+	for i, m := range mappings {
+		if budget := budgets[m.UserID]; budget != nil && m.WorkspaceID == "" {
+			errs[i] = budget.Take(m.Custom)
+		}
+	}
+	return errs, nil
+}
+
+// archiveExpiredOriginal frees up original for a new mapping by owner when
+// the owner's link currently holding it has expired but not yet been swept.
+func archiveExpiredOriginal(ctx context.Context, tx pgx.Tx, owner models.LinkOwner, original string) error {
+	return archiveExpiredOriginals(ctx, tx, []models.LinkOwner{owner}, []string{original})
+}
+
+// archiveExpiredOriginals does the same for each pair of owners[i] and
+// originals[i].
+func archiveExpiredOriginals(ctx context.Context, tx pgx.Tx, owners []models.LinkOwner, originals []string) error {
+	userIDs := make([]string, len(owners))
+	workspaceIDs := make([]string, len(owners))
+	for i, o := range owners {
+		userIDs[i], workspaceIDs[i] = o.UserID, o.WorkspaceID
+	}
+
+	_, err := tx.Exec(ctx, `
+		WITH expired AS (
+			DELETE FROM url_mappings m
+			USING unnest($1::text[], $2::text[], $3::text[]) AS t(user_id, workspace_id, original_url)
+			WHERE m.original_url = t.original_url AND m.expires_at <= now()
+			  AND ((t.workspace_id = '' AND m.user_id = t.user_id AND m.workspace_id IS NULL)
+			    OR (t.workspace_id <> '' AND m.workspace_id = t.workspace_id))
+			RETURNING m.key, m.original_url, m.user_id, m.workspace_id, m.created_at, m.expires_at
+		)
+		INSERT INTO url_mappings_archive (key, original_url, user_id, workspace_id, created_at, expires_at)
+		SELECT key, original_url, user_id, workspace_id, created_at, expires_at FROM expired`,
+		userIDs, workspaceIDs, originals)
+	return pgErr(err)
+}
+
+func (s *PostgresStore) ReserveBlock(ctx context.Context) (int64, int64, error) {
+	var start int64
+	if err := s.db.QueryRow(ctx, `SELECT nextval('url_key_seq')`).Scan(&start); err != nil {
+		return 0, 0, pgErr(err)
+	}
+	return start, postgresKeyBlockSize, nil
+}
+
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
+func (s *PostgresStore) PoolStats() *pgxpool.Stat {
+	return s.db.Stat()
 }
 
 func (s *PostgresStore) Close() error {
@@ -113,7 +456,7 @@ func (s *PostgresStore) GetOrCreateUser(ctx context.Context, user models.User) (
 	}
 
 	if err != pgx.ErrNoRows {
-		return models.User{}, err
+		return models.User{}, pgErr(err)
 	}
 
 	_, err = s.db.Exec(ctx, `
@@ -122,32 +465,556 @@ func (s *PostgresStore) GetOrCreateUser(ctx context.Context, user models.User) (
 		user.ID, user.Email, user.Name, user.Picture,
 	)
 	if err != nil {
-		return models.User{}, err
+		return models.User{}, pgErr(err)
 	}
 
 	user.CreatedAt = time.Now()
 	return user, nil
 }
 
-func (s *PostgresStore) GetURLsByUserID(ctx context.Context, userID string) ([]models.URLMapping, error) {
+func (s *PostgresStore) GetURLsByOwner(ctx context.Context, owner models.LinkOwner) ([]models.URLMapping, error) {
+	var args queryArgs
 	rows, err := s.db.Query(ctx, `
-		SELECT key, original_url, created_at
-		FROM url_mappings
-		WHERE user_id = $1
-		ORDER BY created_at DESC`, userID)
+		SELECT m.key, m.original_url, COALESCE(m.user_id, ''), COALESCE(m.workspace_id, ''), m.created_at, m.expires_at
+		FROM url_mappings m
+		WHERE `+ownerCondition(owner, &args)+`
+		ORDER BY m.created_at DESC`, args...)
 	if err != nil {
-		return nil, err
+		return nil, pgErr(err)
 	}
 	defer rows.Close()
 
 	var urls []models.URLMapping
 	for rows.Next() {
 		var u models.URLMapping
-		if err := rows.Scan(&u.Key, &u.Original, &u.CreatedAt); err != nil {
+		if err := rows.Scan(&u.Key, &u.Original, &u.UserID, &u.WorkspaceID, &u.CreatedAt, &u.ExpiresAt); err != nil {
 			return nil, err
 		}
 		urls = append(urls, u)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, pgErr(err)
+	}
 
 	return urls, nil
 }
+
+func (s *PostgresStore) RecordClicks(ctx context.Context, events []models.ClickEvent) error {
+	rows := make([][]any, len(events))
+	for i, e := range events {
+		rows[i] = []any{e.Key, e.ClickedAt, e.Referrer, e.UserAgent, e.IPHash}
+	}
+
+	_, err := s.db.CopyFrom(ctx,
+		pgx.Identifier{"link_clicks"},
+		[]string{"key", "clicked_at", "referrer", "user_agent", "ip_hash"},
+		pgx.CopyFromRows(rows),
+	)
+	return pgErr(err)
+}
+
+func (s *PostgresStore) GetClickStats(ctx context.Context, key, interval string, from, to time.Time) (models.LinkStats, error) {
+	stats := models.LinkStats{
+		Key:      key,
+		Interval: interval,
+		From:     from,
+		To:       to,
+		Buckets:  []models.ClickBucket{},
+	}
+
+	err := s.db.QueryRow(ctx,
+		`SELECT count(*) FROM link_clicks WHERE key = $1 AND clicked_at >= `+linkCreatedAt, key).Scan(&stats.TotalClicks)
+	if err != nil {
+		return models.LinkStats{}, pgErr(err)
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT date_trunc($2, clicked_at) AS bucket, count(*)
+		FROM link_clicks
+		WHERE key = $1 AND clicked_at >= $3 AND clicked_at < $4 AND clicked_at >= `+linkCreatedAt+`
+		GROUP BY bucket
+		ORDER BY bucket`, key, interval, from, to)
+	if err != nil {
+		return models.LinkStats{}, pgErr(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b models.ClickBucket
+		if err := rows.Scan(&b.Start, &b.Clicks); err != nil {
+			return models.LinkStats{}, err
+		}
+		stats.Buckets = append(stats.Buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return models.LinkStats{}, pgErr(err)
+	}
+
+	return stats, nil
+}
+
+// listSortColumns names each sort's column in the page query, where rows
+// are aliased m, and in the outer query that counts clicks for the page,
+// where they are aliased p.
+var listSortColumns = map[string]struct{ column, outer, cast string }{
+	SortByCreated: {"m.created_at", "p.created_at", "timestamptz"},
+	SortByClicks:  {"c.clicks", "c.clicks", "bigint"},
+	SortByKey:     {"m.key", "p.key", "text"},
+}
+
+// clickCount is joined laterally to count the clicks of the link aliased m,
+// leaving out those made on an earlier link at the same key.
+const clickCount = `CROSS JOIN LATERAL (
+	SELECT count(*) AS clicks FROM link_clicks WHERE key = m.key AND clicked_at >= m.created_at) c`
+
+func (s *PostgresStore) ListURLsByUserID(ctx context.Context, userID string, query models.LinkListQuery) (models.LinkListPage, error) {
+	sort, ok := listSortColumns[query.SortBy]
+	if !ok {
+		return models.LinkListPage{}, fmt.Errorf("unknown sort column: %s", query.SortBy)
+	}
+
+	owner := models.LinkOwner{UserID: userID}
+	if query.WorkspaceID != "" {
+		owner = models.LinkOwner{WorkspaceID: query.WorkspaceID}
+	}
+
+	var args queryArgs
+	where := []string{ownerCondition(owner, &args)}
+	if query.Search != "" {
+		where = append(where, "m.original_url ILIKE "+args.add("%"+escapeLike(query.Search)+"%"))
+	}
+	if query.CreatedFrom != nil {
+		where = append(where, "m.created_at >= "+args.add(*query.CreatedFrom))
+	}
+	if query.CreatedTo != nil {
+		where = append(where, "m.created_at < "+args.add(*query.CreatedTo))
+	}
+
+	cmp, dir := ">", "ASC"
+	if query.Descending {
+		cmp, dir = "<", "DESC"
+	}
+	if query.Cursor != "" {
+		c, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return models.LinkListPage{}, err
+		}
+		after, err := cursorRow(query.SortBy, c)
+		if err != nil {
+			return models.LinkListPage{}, err
+		}
+		where = append(where, fmt.Sprintf("(%s, m.key) %s (%s::%s, %s)",
+			sort.column, cmp, args.add(after.sortArg(query.SortBy)), sort.cast, args.add(c.Key)))
+	}
+
+	// The page is picked before clicks are counted for it, unless it is
+	// ordered by clicks, which needs every link's count.
+	var pageJoin string
+	if query.SortBy == SortByClicks {
+		pageJoin = clickCount
+	}
+	sql := fmt.Sprintf(`
+		SELECT p.key, p.original_url, COALESCE(p.user_id, ''), COALESCE(p.workspace_id, ''),
+		       p.created_at, p.expires_at, c.clicks
+		FROM (
+			SELECT m.key, m.original_url, m.user_id, m.workspace_id, m.created_at, m.expires_at
+			FROM url_mappings m %s
+			WHERE %s
+			ORDER BY %s %s, m.key %s
+			LIMIT %s
+		) p
+		CROSS JOIN LATERAL (
+			SELECT count(*) AS clicks FROM link_clicks WHERE key = p.key AND clicked_at >= p.created_at) c
+		ORDER BY %s %s, p.key %s`,
+		pageJoin, strings.Join(where, " AND "), sort.column, dir, dir, args.add(query.Limit+1),
+		sort.outer, dir, dir)
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return models.LinkListPage{}, pgErr(err)
+	}
+	defer rows.Close()
+
+	var listed []listRow
+	for rows.Next() {
+		var row listRow
+		item := &row.item
+		if err := rows.Scan(&item.Key, &item.Original, &item.UserID, &item.WorkspaceID,
+			&row.createdAt, &item.ExpiresAt, &item.Clicks); err != nil {
+			return models.LinkListPage{}, err
+		}
+		item.CreatedAt = row.createdAt.UTC().Format(time.RFC3339)
+		listed = append(listed, row)
+	}
+	if err := rows.Err(); err != nil {
+		return models.LinkListPage{}, pgErr(err)
+	}
+
+	page := models.LinkListPage{Links: []models.LinkListItem{}}
+	for i, row := range listed {
+		if i == query.Limit {
+			last := listed[i-1]
+			page.NextCursor = encodeCursor(last.sortValue(query.SortBy), last.item.Key)
+			break
+		}
+		page.Links = append(page.Links, row.item)
+	}
+	return page, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (s *PostgresStore) CreateToken(ctx context.Context, token models.APIToken, tokenHash string) (models.APIToken, error) {
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO api_tokens (id, user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`,
+		token.ID, token.UserID, token.Name, tokenHash, token.Scopes, token.ExpiresAt,
+	).Scan(&token.CreatedAt)
+	if err != nil {
+		return models.APIToken{}, pgErr(err)
+	}
+	return token, nil
+}
+
+func (s *PostgresStore) ListTokens(ctx context.Context, userID string) ([]models.APIToken, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, name, scopes, created_at, expires_at, last_used_at
+		FROM api_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, pgErr(err)
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		t := models.APIToken{UserID: userID}
+		if err := rows.Scan(&t.ID, &t.Name, &t.Scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgErr(err)
+	}
+
+	return tokens, nil
+}
+
+func (s *PostgresStore) RevokeToken(ctx context.Context, userID, tokenID string) error {
+	cmdTag, err := s.db.Exec(ctx, `
+		UPDATE api_tokens SET revoked_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, tokenID, userID)
+	if err != nil {
+		return pgErr(err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) AuthenticateToken(ctx context.Context, tokenHash string) (models.User, models.APIToken, error) {
+	var user models.User
+	var token models.APIToken
+
+	err := s.db.QueryRow(ctx, `
+		UPDATE api_tokens t SET last_used_at = now()
+		FROM users u
+		WHERE t.token_hash = $1 AND t.user_id = u.id
+		  AND t.revoked_at IS NULL AND (t.expires_at IS NULL OR t.expires_at > now())
+		RETURNING t.id, t.name, t.scopes, t.created_at, t.expires_at, t.last_used_at,
+		          u.id, u.email, u.name, u.picture_url, u.created_at`, tokenHash,
+	).Scan(&token.ID, &token.Name, &token.Scopes, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt,
+		&user.ID, &user.Email, &user.Name, &user.Picture, &user.CreatedAt)
+	if err != nil {
+		return models.User{}, models.APIToken{}, pgErr(err)
+	}
+
+	token.UserID = user.ID
+	return user, token, nil
+}
+
+func (s *PostgresStore) GetOrCreateUserByIdentity(ctx context.Context, identity models.Identity) (models.User, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return models.User{}, pgErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var user models.User
+	err = tx.QueryRow(ctx, `
+		SELECT u.id, u.email, u.name, u.picture_url, u.created_at
+		FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.subject = $2`,
+		identity.Provider, identity.Subject,
+	).Scan(&user.ID, &user.Email, &user.Name, &user.Picture, &user.CreatedAt)
+	if err == nil {
+		return user, nil
+	}
+	if err != pgx.ErrNoRows {
+		return models.User{}, pgErr(err)
+	}
+
+	err = tx.QueryRow(ctx, `
+		SELECT id, email, name, picture_url, created_at FROM users WHERE email = $1`,
+		identity.Email,
+	).Scan(&user.ID, &user.Email, &user.Name, &user.Picture, &user.CreatedAt)
+	if err == nil && !(identity.EmailVerified && identity.TrustedEmail) {
+		return models.User{}, ErrEmailInUse
+	}
+	if err == pgx.ErrNoRows {
+		user = models.User{
+			ID:      uuid.New().String(),
+			Email:   identity.Email,
+			Name:    identity.Name,
+			Picture: identity.Picture,
+		}
+		err = tx.QueryRow(ctx, `
+			INSERT INTO users (id, email, name, picture_url, created_at)
+			VALUES ($1, $2, $3, $4, NOW())
+			RETURNING created_at`,
+			user.ID, user.Email, user.Name, user.Picture,
+		).Scan(&user.CreatedAt)
+	}
+	if err != nil {
+		return models.User{}, pgErr(err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)`,
+		identity.Provider, identity.Subject, user.ID, identity.Email)
+	if err != nil {
+		return models.User{}, pgErr(err)
+	}
+
+	return user, pgErr(tx.Commit(ctx))
+}
+
+func (s *PostgresStore) LinkIdentity(ctx context.Context, userID string, identity models.Identity) error {
+	var linkedTo string
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, subject) DO UPDATE SET provider = EXCLUDED.provider
+		RETURNING user_id`,
+		identity.Provider, identity.Subject, userID, identity.Email,
+	).Scan(&linkedTo)
+	if err != nil {
+		return pgErr(err)
+	}
+	if linkedTo != userID {
+		return fmt.Errorf("%w: identity is linked to another user", ErrConflict)
+	}
+	return nil
+}
+
+func (s *PostgresStore) CreateWorkspace(ctx context.Context, name, ownerID string) (models.Workspace, error) {
+	ws := models.Workspace{ID: uuid.New().String(), Name: name, Role: models.RoleOwner}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return models.Workspace{}, pgErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO workspaces (id, name, created_by) VALUES ($1, $2, $3)
+		RETURNING created_at`, ws.ID, ws.Name, ownerID).Scan(&ws.CreatedAt)
+	if err != nil {
+		return models.Workspace{}, pgErr(err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3)`,
+		ws.ID, ownerID, models.RoleOwner)
+	if err != nil {
+		return models.Workspace{}, pgErr(err)
+	}
+	return ws, pgErr(tx.Commit(ctx))
+}
+
+func (s *PostgresStore) ListWorkspaces(ctx context.Context, userID string) ([]models.Workspace, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT w.id, w.name, w.created_at, m.role
+		FROM workspace_members m JOIN workspaces w ON w.id = m.workspace_id
+		WHERE m.user_id = $1
+		ORDER BY w.created_at`, userID)
+	if err != nil {
+		return nil, pgErr(err)
+	}
+	defer rows.Close()
+
+	workspaces := []models.Workspace{}
+	for rows.Next() {
+		var ws models.Workspace
+		if err := rows.Scan(&ws.ID, &ws.Name, &ws.CreatedAt, &ws.Role); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, ws)
+	}
+	return workspaces, pgErr(rows.Err())
+}
+
+func (s *PostgresStore) GetMemberRole(ctx context.Context, workspaceID, userID string) (string, error) {
+	var role string
+	err := s.db.QueryRow(ctx, `
+		SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2`,
+		workspaceID, userID).Scan(&role)
+	return role, pgErr(err)
+}
+
+func (s *PostgresStore) ListMembers(ctx context.Context, workspaceID string) ([]models.WorkspaceMember, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT u.id, u.email, COALESCE(u.name, ''), m.role, m.added_at, false
+		FROM workspace_members m JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id = $1
+		UNION ALL
+		SELECT '', email, '', role, invited_at, true
+		FROM workspace_invites
+		WHERE workspace_id = $1
+		ORDER BY 6, 5`, workspaceID)
+	if err != nil {
+		return nil, pgErr(err)
+	}
+	defer rows.Close()
+
+	members := []models.WorkspaceMember{}
+	for rows.Next() {
+		var m models.WorkspaceMember
+		if err := rows.Scan(&m.UserID, &m.Email, &m.Name, &m.Role, &m.AddedAt, &m.Pending); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, pgErr(rows.Err())
+}
+
+func (s *PostgresStore) AddMember(ctx context.Context, workspaceID, email, role string) (models.WorkspaceMember, error) {
+	m := models.WorkspaceMember{Role: role}
+	err := s.db.QueryRow(ctx, `
+		WITH u AS (
+			SELECT id, email, COALESCE(name, '') AS name FROM users
+			WHERE lower(email) = lower($2)
+			ORDER BY email = $2 DESC
+			LIMIT 1
+		), added AS (
+			INSERT INTO workspace_members (workspace_id, user_id, role)
+			SELECT $1, id, $3 FROM u
+			RETURNING user_id, added_at
+		)
+		SELECT u.id, u.email, u.name, added.added_at FROM added JOIN u ON u.id = added.user_id`,
+		workspaceID, email, role,
+	).Scan(&m.UserID, &m.Email, &m.Name, &m.AddedAt)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		if err != nil {
+			return models.WorkspaceMember{}, pgErr(err)
+		}
+		return m, nil
+	}
+
+	m = models.WorkspaceMember{Email: strings.ToLower(email), Role: role, Pending: true}
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO workspace_invites (workspace_id, email, role) VALUES ($1, $2, $3)
+		ON CONFLICT (workspace_id, email) DO UPDATE SET role = EXCLUDED.role, invited_at = now()
+		RETURNING invited_at`, workspaceID, m.Email, role).Scan(&m.AddedAt)
+	if err != nil {
+		return models.WorkspaceMember{}, pgErr(err)
+	}
+	return m, nil
+}
+
+func (s *PostgresStore) AcceptInvites(ctx context.Context, userID, email string) error {
+	_, err := s.db.Exec(ctx, `
+		WITH accepted AS (
+			DELETE FROM workspace_invites WHERE email = $2
+			RETURNING workspace_id, role
+		)
+		INSERT INTO workspace_members (workspace_id, user_id, role)
+		SELECT workspace_id, $1, role FROM accepted
+		ON CONFLICT DO NOTHING`, userID, strings.ToLower(email))
+	return pgErr(err)
+}
+
+func (s *PostgresStore) UpdateMemberRole(ctx context.Context, workspaceID, userID, role string) (models.WorkspaceMember, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return models.WorkspaceMember{}, pgErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	owners, err := lockOwners(ctx, tx, workspaceID)
+	if err != nil {
+		return models.WorkspaceMember{}, err
+	}
+
+	// old is a second scan of the row, so it still holds the previous role.
+	m := models.WorkspaceMember{UserID: userID, Role: role}
+	var oldRole string
+	err = tx.QueryRow(ctx, `
+		UPDATE workspace_members m SET role = $3
+		FROM workspace_members old JOIN users u ON u.id = old.user_id
+		WHERE m.workspace_id = $1 AND m.user_id = $2
+		  AND old.workspace_id = m.workspace_id AND old.user_id = m.user_id
+		RETURNING old.role, u.email, COALESCE(u.name, ''), m.added_at`,
+		workspaceID, userID, role).Scan(&oldRole, &m.Email, &m.Name, &m.AddedAt)
+	if err != nil {
+		return models.WorkspaceMember{}, pgErr(err)
+	}
+	if oldRole == models.RoleOwner && role != models.RoleOwner && owners <= 1 {
+		return models.WorkspaceMember{}, fmt.Errorf("%w: a workspace must keep at least one owner", ErrConflict)
+	}
+	return m, pgErr(tx.Commit(ctx))
+}
+
+func (s *PostgresStore) RemoveMember(ctx context.Context, workspaceID, userID string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return pgErr(err)
+	}
+	defer tx.Rollback(ctx)
+
+	owners, err := lockOwners(ctx, tx, workspaceID)
+	if err != nil {
+		return err
+	}
+
+	var role string
+	err = tx.QueryRow(ctx, `
+		DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2
+		RETURNING role`, workspaceID, userID).Scan(&role)
+	if err != nil {
+		return pgErr(err)
+	}
+	if role == models.RoleOwner && owners <= 1 {
+		return fmt.Errorf("%w: a workspace must keep at least one owner", ErrConflict)
+	}
+	return pgErr(tx.Commit(ctx))
+}
+
+func (s *PostgresStore) RevokeInvite(ctx context.Context, workspaceID, email string) error {
+	cmdTag, err := s.db.Exec(ctx,
+		`DELETE FROM workspace_invites WHERE workspace_id = $1 AND email = $2`,
+		workspaceID, strings.ToLower(email))
+	if err != nil {
+		return pgErr(err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// lockOwners counts the workspace's owners, locking their memberships so two
+// owners cannot remove or demote each other at once.
+func lockOwners(ctx context.Context, tx pgx.Tx, workspaceID string) (int, error) {
+	var owners int
+	err := tx.QueryRow(ctx, `
+		SELECT count(*) FROM (
+			SELECT 1 FROM workspace_members WHERE workspace_id = $1 AND role = $2 FOR UPDATE
+		) o`, workspaceID, models.RoleOwner).Scan(&owners)
+	return owners, pgErr(err)
+}
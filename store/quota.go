@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+// QuotaStore reports a user's plan and how much of it they have used. Only
+// personal links count; workspace links are not metered against anyone's
+// plan.
+type QuotaStore interface {
+	// GetUsage counts the user's unexpired personal links and custom keys,
+	// and the clicks on those links since the given time.
+	GetUsage(ctx context.Context, userID string, clicksSince time.Time) (models.Usage, error)
+}
+
+// LinkBudget is how many more links and custom keys a user may create under
+// their plan. A negative count means unlimited.
+type LinkBudget struct {
+	plan       models.Plan
+	links      int64
+	customKeys int64
+}
+
+func NewLinkBudget(usage models.Usage) *LinkBudget {
+	plan := models.LookupPlan(usage.Plan)
+	return &LinkBudget{
+		plan:       plan,
+		links:      remaining(plan.MaxLinks, usage.Links),
+		customKeys: remaining(plan.MaxCustomKeys, usage.CustomKeys),
+	}
+}
+
+func remaining(limit, used int64) int64 {
+	if limit == 0 {
+		return -1
+	}
+	return max(0, limit-used)
+}
+
+// Take reserves room for one more link, returning an error wrapping
+// ErrQuotaExceeded if the plan has none left. A nil budget is unlimited.
+func (b *LinkBudget) Take(custom bool) error {
+	if b == nil {
+		return nil
+	}
+	if b.links == 0 {
+		return fmt.Errorf("%w: the %s plan allows %d links", ErrQuotaExceeded, b.plan.Name, b.plan.MaxLinks)
+	}
+	if custom && b.customKeys == 0 {
+		return fmt.Errorf("%w: the %s plan allows %d custom keys", ErrQuotaExceeded, b.plan.Name, b.plan.MaxCustomKeys)
+	}
+
+	if b.links > 0 {
+		b.links--
+	}
+	if custom && b.customKeys > 0 {
+		b.customKeys--
+	}
+	return nil
+}
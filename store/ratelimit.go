@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const rateLimitPrefix = "ratelimit:"
+
+// RateLimitResult describes the state of a bucket after a request.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long a rejected caller should wait. Reset is when
+	// the bucket is next guaranteed to have room.
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// RateLimiter counts requests per bucket over a sliding window.
+type RateLimiter interface {
+	Allow(ctx context.Context, bucket string, limit int, window time.Duration) (RateLimitResult, error)
+}
+
+// slidingWindow estimates the request count over the last window from the
+// current and previous fixed windows, weighting the previous one by how
+// much of it still overlaps.
+func slidingWindow(curr, prev int64, limit int, window, elapsed time.Duration) RateLimitResult {
+	weight := 1 - float64(elapsed)/float64(window)
+	count := int64(float64(prev)*weight) + curr
+
+	result := RateLimitResult{
+		Allowed:   count < int64(limit),
+		Limit:     limit,
+		Remaining: max(0, limit-int(count)-1),
+		Reset:     window - elapsed,
+	}
+	if result.Allowed {
+		return result
+	}
+
+	result.Remaining = 0
+	if curr >= int64(limit) || prev == 0 {
+		// Only the next window can make room.
+		result.RetryAfter = window - elapsed
+		return result
+	}
+	// The previous window's share decays linearly; wait until enough of it
+	// has dropped out.
+	excess := float64(count-int64(limit)+1) / float64(prev)
+	result.RetryAfter = min(time.Duration(excess*float64(window)), window-elapsed)
+	return result
+}
+
+func windowStart(now time.Time, window time.Duration) (int64, time.Duration) {
+	index := now.UnixNano() / int64(window)
+	return index, time.Duration(now.UnixNano() - index*int64(window))
+}
+
+// allowScript counts a request in the current window unless the estimate
+// is already at the limit. It returns the current and previous counts
+// before this request.
+var allowScript = redis.NewScript(`
+local curr = tonumber(redis.call('GET', KEYS[1]) or '0')
+local prev = tonumber(redis.call('GET', KEYS[2]) or '0')
+local limit = tonumber(ARGV[1])
+local weight = tonumber(ARGV[2])
+if math.floor(prev * weight) + curr < limit then
+	redis.call('INCR', KEYS[1])
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return {curr, prev}
+`)
+
+// RedisRateLimiter shares limits across replicas. While Redis is
+// unreachable it falls back to per-instance limits.
+type RedisRateLimiter struct {
+	client  *redis.Client
+	breaker *CircuitBreaker
+	local   *MemoryRateLimiter
+}
+
+var _ RateLimiter = (*RedisRateLimiter)(nil)
+
+func NewRedisRateLimiter(client *redis.Client, breaker *CircuitBreaker) *RedisRateLimiter {
+	return &RedisRateLimiter{client: client, breaker: breaker, local: NewMemoryRateLimiter()}
+}
+
+func (l *RedisRateLimiter) Allow(ctx context.Context, bucket string, limit int, window time.Duration) (RateLimitResult, error) {
+	if !l.breaker.Allow() {
+		return l.local.Allow(ctx, bucket, limit, window)
+	}
+
+	index, elapsed := windowStart(time.Now(), window)
+	prefix := rateLimitPrefix + bucket + ":"
+	keys := []string{prefix + strconv.FormatInt(index, 10), prefix + strconv.FormatInt(index-1, 10)}
+	weight := 1 - float64(elapsed)/float64(window)
+
+	counts, err := allowScript.Run(ctx, l.client, keys,
+		limit, strconv.FormatFloat(weight, 'f', 6, 64), (2 * window).Milliseconds()).Int64Slice()
+	l.breaker.Record(redisErr(err))
+	if err != nil {
+		log.Printf("[ratelimit] redis unavailable, using local limits: %v", err)
+		return l.local.Allow(ctx, bucket, limit, window)
+	}
+	return slidingWindow(counts[0], counts[1], limit, window, elapsed), nil
+}
+
+type memoryWindow struct {
+	window     time.Duration
+	index      int64
+	curr, prev int64
+}
+
+// MemoryRateLimiter keeps counts in process, for single-instance and
+// in-memory deployments.
+type MemoryRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryWindow
+	sweep   time.Time
+}
+
+var _ RateLimiter = (*MemoryRateLimiter)(nil)
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: make(map[string]*memoryWindow)}
+}
+
+func (l *MemoryRateLimiter) Allow(ctx context.Context, bucket string, limit int, window time.Duration) (RateLimitResult, error) {
+	now := time.Now()
+	index, elapsed := windowStart(now, window)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweepStale(now)
+
+	w, ok := l.buckets[bucket]
+	if !ok || w.window != window {
+		w = &memoryWindow{window: window, index: index}
+		l.buckets[bucket] = w
+	}
+	switch {
+	case w.index == index-1:
+		w.index, w.prev, w.curr = index, w.curr, 0
+	case w.index < index-1:
+		w.index, w.prev, w.curr = index, 0, 0
+	}
+
+	result := slidingWindow(w.curr, w.prev, limit, window, elapsed)
+	if result.Allowed {
+		w.curr++
+	}
+	return result, nil
+}
+
+// sweepStale drops idle buckets now and then so the map does not grow with
+// every client ever seen. Callers must hold l.mu.
+func (l *MemoryRateLimiter) sweepStale(now time.Time) {
+	if now.Sub(l.sweep) < time.Minute {
+		return
+	}
+	l.sweep = now
+
+	for bucket, w := range l.buckets {
+		if index, _ := windowStart(now, w.window); w.index < index-1 {
+			delete(l.buckets, bucket)
+		}
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/JamieLeeNZ/url-shortener/models"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -15,8 +18,32 @@ type RedisStore struct {
 }
 
 type cachedURL struct {
-	OriginalURL string `json:"original_url"`
-	UserID      string `json:"user_id"`
+	OriginalURL string     `json:"original_url"`
+	UserID      string     `json:"user_id"`
+	WorkspaceID string     `json:"workspace_id,omitempty"`
+	CreatedAt   string     `json:"created_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+func newCachedURL(m models.URLMapping) cachedURL {
+	return cachedURL{
+		OriginalURL: m.Original,
+		UserID:      m.UserID,
+		WorkspaceID: m.WorkspaceID,
+		CreatedAt:   m.CreatedAt,
+		ExpiresAt:   m.ExpiresAt,
+	}
+}
+
+func (c cachedURL) toMapping(key string) models.URLMapping {
+	return models.URLMapping{
+		Key:         key,
+		Original:    c.OriginalURL,
+		UserID:      c.UserID,
+		WorkspaceID: c.WorkspaceID,
+		CreatedAt:   c.CreatedAt,
+		ExpiresAt:   c.ExpiresAt,
+	}
 }
 
 var _ RedisClientProvider = (*RedisStore)(nil)
@@ -26,7 +53,9 @@ func (r *RedisStore) RawClient() *redis.Client {
 	return r.client
 }
 
-func NewRedisStore(addr, password string, db int, ttl time.Duration) (*RedisStore, error) {
+// NewRedisStore does not wait for Redis to answer, so the service can start
+// while it is down; use Ping to check the connection.
+func NewRedisStore(addr, password string, db int, ttl time.Duration) *RedisStore {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:      addr,
 		Password:  password,
@@ -34,124 +63,260 @@ func NewRedisStore(addr, password string, db int, ttl time.Duration) (*RedisStor
 		TLSConfig: &tls.Config{},
 	})
 
-	if err := rdb.Ping(context.Background()).Err(); err != nil {
-		return nil, err
-	}
-
 	return &RedisStore{
 		client: rdb,
 		ttl:    ttl,
-	}, nil
+	}
 }
 
-func (r *RedisStore) Set(ctx context.Context, key, original, userID string) error {
-	data := cachedURL{OriginalURL: original, UserID: userID}
+// ttlFor caps the cache TTL so an entry never outlives the link itself.
+func (r *RedisStore) ttlFor(expiresAt *time.Time) time.Duration {
+	if expiresAt == nil {
+		return r.ttl
+	}
+	if until := time.Until(*expiresAt); until < r.ttl {
+		return until
+	}
+	return r.ttl
+}
+
+// missingMarker is stored in place of a mapping for keys known not to exist.
+const missingMarker = "!missing"
+
+// originalIndexKey names the reverse index entry from an owner's
+// destination to their key.
+func originalIndexKey(owner models.LinkOwner, original string) string {
+	if owner.WorkspaceID != "" {
+		return "original:workspace:" + owner.WorkspaceID + ":" + original
+	}
+	return "original:" + owner.UserID + ":" + original
+}
+
+func (r *RedisStore) set(ctx context.Context, key string, data cachedURL) error {
+	ttl := r.ttlFor(data.ExpiresAt)
+	if ttl <= 0 {
+		// Still clear any negative entry so the key is not reported missing.
+		return redisErr(r.client.Del(ctx, key).Err())
+	}
+
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
 
-	err = r.client.Set(ctx, key, jsonData, r.ttl).Err()
+	err = r.client.Set(ctx, key, jsonData, ttl).Err()
 	if err != nil {
-		return err
+		return redisErr(err)
 	}
 
-	err = r.client.Set(ctx, "original:"+original, key, r.ttl).Err()
-	return err
+	err = r.client.Set(ctx, originalIndexKey(data.toMapping(key).Owner(), data.OriginalURL), key, ttl).Err()
+	return redisErr(err)
 }
 
-func (r *RedisStore) GetOriginalFromKey(ctx context.Context, key string) (string, string, bool) {
-	val, err := r.client.Get(ctx, key).Result()
-	if err == redis.Nil || err != nil {
-		return "", "", false
-	}
+func (r *RedisStore) Set(ctx context.Context, mapping models.URLMapping) error {
+	return r.set(ctx, mapping.Key, newCachedURL(mapping))
+}
 
-	var data cachedURL
-	if err := json.Unmarshal([]byte(val), &data); err != nil {
-		return "", "", false
+// SetBatch warms the cache with mappings in a single pipelined round trip.
+func (r *RedisStore) SetBatch(ctx context.Context, mappings []models.URLMapping) ([]error, error) {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, m := range mappings {
+			data := newCachedURL(m)
+			ttl := r.ttlFor(data.ExpiresAt)
+			if ttl <= 0 {
+				pipe.Del(ctx, m.Key)
+				continue
+			}
+			jsonData, err := json.Marshal(data)
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, m.Key, jsonData, ttl)
+			pipe.Set(ctx, originalIndexKey(m.Owner(), m.Original), m.Key, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, redisErr(err)
 	}
-
-	return data.OriginalURL, data.UserID, true
+	return make([]error, len(mappings)), nil
 }
 
-func (r *RedisStore) Update(ctx context.Context, key, newValue string) bool {
+func (r *RedisStore) get(ctx context.Context, key string) (cachedURL, error) {
 	val, err := r.client.Get(ctx, key).Result()
-	if err == redis.Nil || err != nil {
-		return false
+	if err != nil {
+		return cachedURL{}, redisErr(err)
+	}
+	if val == missingMarker {
+		return cachedURL{}, errKnownMissing
 	}
 
 	var data cachedURL
 	if err := json.Unmarshal([]byte(val), &data); err != nil {
-		return false
+		return cachedURL{}, err
 	}
+	return data, nil
+}
 
-	r.client.Del(ctx, "original:"+data.OriginalURL)
+func (r *RedisStore) Get(ctx context.Context, key string) (models.URLMapping, error) {
+	data, err := r.get(ctx, key)
+	if err != nil {
+		return models.URLMapping{}, err
+	}
 
-	data.OriginalURL = newValue
+	return data.toMapping(key), nil
+}
 
-	newJSON, err := json.Marshal(data)
+func (r *RedisStore) Update(ctx context.Context, key, newValue string, expiresAt *time.Time, clearExpiry bool, editedBy string) error {
+	data, err := r.get(ctx, key)
 	if err != nil {
-		return false
+		return err
 	}
 
-	err = r.client.Set(ctx, key, newJSON, r.ttl).Err()
-	if err != nil {
-		return false
+	if err := r.DeleteOriginal(ctx, data.toMapping(key).Owner(), data.OriginalURL); err != nil {
+		return err
 	}
 
-	err = r.client.Set(ctx, "original:"+newValue, key, r.ttl).Err()
-	return err == nil
+	data.OriginalURL = newValue
+	if clearExpiry {
+		data.ExpiresAt = nil
+	} else if expiresAt != nil {
+		data.ExpiresAt = expiresAt
+	}
+
+	return r.set(ctx, key, data)
 }
 
-func (r *RedisStore) ContainsKey(ctx context.Context, key string) bool {
-	_, err := r.client.Get(ctx, key).Result()
-	if err == redis.Nil {
-		return false
-	} else if err != nil {
-		return false
+func (r *RedisStore) ContainsKey(ctx context.Context, key string) (bool, error) {
+	_, err := r.get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
 	}
-	return true
+	return err == nil, err
 }
 
-func (r *RedisStore) GetKeyFromOriginal(ctx context.Context, original string) (string, string, bool) {
-	key, err := r.client.Get(ctx, "original:"+original).Result()
-	if err == redis.Nil || err != nil {
-		return "", "", false
-	}
+// SetMissing records that key does not exist. It never replaces a cached
+// mapping, so a key created in the meantime is not hidden.
+func (r *RedisStore) SetMissing(ctx context.Context, key string, ttl time.Duration) error {
+	return redisErr(r.client.SetNX(ctx, key, missingMarker, ttl).Err())
+}
 
-	val, err := r.client.Get(ctx, key).Result()
-	if err == redis.Nil || err != nil {
-		return "", "", false
+func (r *RedisStore) GetByOriginal(ctx context.Context, owner models.LinkOwner, original string) (models.URLMapping, error) {
+	key, err := r.client.Get(ctx, originalIndexKey(owner, original)).Result()
+	if err != nil {
+		return models.URLMapping{}, redisErr(err)
 	}
 
-	var data cachedURL
-	if err := json.Unmarshal([]byte(val), &data); err != nil {
-		return "", "", false
+	data, err := r.get(ctx, key)
+	if err != nil {
+		return models.URLMapping{}, err
 	}
 
-	return key, data.UserID, true
+	// The index is left behind when a key is changed while uncached.
+	mapping := data.toMapping(key)
+	if data.OriginalURL != original || mapping.Owner() != owner {
+		if err := r.DeleteOriginal(ctx, owner, original); err != nil {
+			return models.URLMapping{}, err
+		}
+		return models.URLMapping{}, ErrNotFound
+	}
+	return mapping, nil
 }
 
-func (r *RedisStore) Delete(ctx context.Context, key string) bool {
-	val, err := r.client.Get(ctx, key).Result()
-	if err == redis.Nil || err != nil {
-		return false
-	}
+var _ OriginalIndex = (*RedisStore)(nil)
 
-	var data cachedURL
-	if err := json.Unmarshal([]byte(val), &data); err != nil {
-		return false
+func (r *RedisStore) DeleteOriginal(ctx context.Context, owner models.LinkOwner, original string) error {
+	return redisErr(r.client.Del(ctx, originalIndexKey(owner, original)).Err())
+}
+
+func (r *RedisStore) Delete(ctx context.Context, key string) error {
+	data, err := r.get(ctx, key)
+	if err != nil {
+		return err
 	}
 
 	err = r.client.Del(ctx, key).Err()
 	if err != nil {
-		return false
+		return redisErr(err)
 	}
 
-	err = r.client.Del(ctx, "original:"+data.OriginalURL).Err()
-	return err == nil
+	err = r.client.Del(ctx, originalIndexKey(data.toMapping(key).Owner(), data.OriginalURL)).Err()
+	return redisErr(err)
+}
+
+const invalidationChannel = "cache:invalidate"
+
+var _ Invalidator = (*RedisStore)(nil)
+
+func (r *RedisStore) PublishInvalidation(ctx context.Context, key string) error {
+	return redisErr(r.client.Publish(ctx, invalidationChannel, key).Err())
+}
+
+// SubscribeInvalidations streams keys published by PublishInvalidation.
+// The subscription reconnects on its own if Redis drops; the channel is
+// closed once ctx is cancelled.
+func (r *RedisStore) SubscribeInvalidations(ctx context.Context) <-chan string {
+	pubsub := r.client.Subscribe(ctx, invalidationChannel)
+	keys := make(chan string)
+
+	go func() {
+		defer close(keys)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case keys <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return keys
+}
+
+func (r *RedisStore) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
 }
 
 func (r *RedisStore) Close() error {
 	return r.client.Close()
 }
+
+const keyCounterName = "counter:url_keys"
+
+// RedisCounter reserves key blocks from a Redis counter. It shares the
+// cache's breaker, so while Redis is down creates fail fast instead of
+// each waiting for a timeout.
+type RedisCounter struct {
+	client    *redis.Client
+	breaker   *CircuitBreaker
+	blockSize int64
+}
+
+var _ CounterSource = (*RedisCounter)(nil)
+
+func NewRedisCounter(client *redis.Client, breaker *CircuitBreaker, blockSize int64) *RedisCounter {
+	return &RedisCounter{client: client, breaker: breaker, blockSize: blockSize}
+}
+
+func (c *RedisCounter) ReserveBlock(ctx context.Context) (int64, int64, error) {
+	if !c.breaker.Allow() {
+		return 0, 0, fmt.Errorf("%w: redis circuit open", ErrUnavailable)
+	}
+	end, err := c.client.IncrBy(ctx, keyCounterName, c.blockSize).Result()
+	err = redisErr(err)
+	c.breaker.Record(err)
+	if err != nil {
+		return 0, 0, err
+	}
+	return end - c.blockSize, c.blockSize, nil
+}
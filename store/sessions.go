@@ -0,0 +1,316 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	sessionPrefix = "session:"
+	// activeSessionsKey indexes live session IDs by expiry time so they can
+	// be counted without scanning the keyspace.
+	activeSessionsKey = "sessions:active"
+)
+
+type SessionStore interface {
+	SetSession(ctx context.Context, id string, user models.User, ttl time.Duration) error
+	GetSession(ctx context.Context, id string) (models.User, error)
+	TouchSession(ctx context.Context, id string, ttl time.Duration) error
+	DeleteSession(ctx context.Context, id string) error
+	CountSessions(ctx context.Context) (int64, error)
+}
+
+type RedisSessionStore struct {
+	client *redis.Client
+}
+
+func NewRedisSessionStore(client *redis.Client) *RedisSessionStore {
+	return &RedisSessionStore{client: client}
+}
+
+var _ SessionStore = (*RedisSessionStore)(nil)
+
+func (r *RedisSessionStore) SetSession(ctx context.Context, id string, user models.User, ttl time.Duration) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, sessionPrefix+id, data, ttl)
+		pipe.ZAdd(ctx, activeSessionsKey, redis.Z{Score: expiryScore(ttl), Member: id})
+		return nil
+	})
+	return redisErr(err)
+}
+
+func expiryScore(ttl time.Duration) float64 {
+	return float64(time.Now().Add(ttl).Unix())
+}
+
+func (r *RedisSessionStore) GetSession(ctx context.Context, id string) (models.User, error) {
+	data, err := r.client.Get(ctx, sessionPrefix+id).Result()
+	if err != nil {
+		return models.User{}, redisErr(err)
+	}
+
+	var user models.User
+	if err := json.Unmarshal([]byte(data), &user); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (r *RedisSessionStore) TouchSession(ctx context.Context, id string, ttl time.Duration) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Expire(ctx, sessionPrefix+id, ttl)
+		pipe.ZAdd(ctx, activeSessionsKey, redis.Z{Score: expiryScore(ttl), Member: id})
+		return nil
+	})
+	return redisErr(err)
+}
+
+func (r *RedisSessionStore) DeleteSession(ctx context.Context, id string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionPrefix+id)
+		pipe.ZRem(ctx, activeSessionsKey, id)
+		return nil
+	})
+	return redisErr(err)
+}
+
+func (r *RedisSessionStore) CountSessions(ctx context.Context) (int64, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := r.client.ZRemRangeByScore(ctx, activeSessionsKey, "-inf", now).Err(); err != nil {
+		return 0, redisErr(err)
+	}
+	n, err := r.client.ZCard(ctx, activeSessionsKey).Result()
+	return n, redisErr(err)
+}
+
+type memorySession struct {
+	user      models.User
+	expiresAt time.Time
+}
+
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]memorySession),
+	}
+}
+
+var _ SessionStore = (*MemorySessionStore)(nil)
+
+func (m *MemorySessionStore) SetSession(ctx context.Context, id string, user models.User, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[id] = memorySession{user: user, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *MemorySessionStore) GetSession(ctx context.Context, id string) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sess, ok := m.sessions[id]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	if time.Now().After(sess.expiresAt) {
+		delete(m.sessions, id)
+		return models.User{}, ErrNotFound
+	}
+	return sess.user, nil
+}
+
+func (m *MemorySessionStore) TouchSession(ctx context.Context, id string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sess, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	sess.expiresAt = time.Now().Add(ttl)
+	m.sessions[id] = sess
+	return nil
+}
+
+func (m *MemorySessionStore) DeleteSession(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemorySessionStore) CountSessions(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var n int64
+	for id, sess := range m.sessions {
+		if now.After(sess.expiresAt) {
+			delete(m.sessions, id)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// FallbackSessionStore keeps sessions in a primary store (Redis) and
+// mirrors them into a local in-memory store. While the breaker is open,
+// sessions are served from the local copy so users stay logged in, and
+// sessions created or deleted in that window are replayed to the primary
+// once it answers again. The local copy only covers users seen by this
+// instance.
+type FallbackSessionStore struct {
+	primary SessionStore
+	local   *MemorySessionStore
+	breaker *CircuitBreaker
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+	revoked map[string]struct{}
+}
+
+func NewFallbackSessionStore(primary SessionStore, breaker *CircuitBreaker, ttl time.Duration) *FallbackSessionStore {
+	return &FallbackSessionStore{
+		primary: primary,
+		local:   NewMemorySessionStore(),
+		breaker: breaker,
+		ttl:     ttl,
+		pending: make(map[string]struct{}),
+		revoked: make(map[string]struct{}),
+	}
+}
+
+var _ SessionStore = (*FallbackSessionStore)(nil)
+
+func (f *FallbackSessionStore) SetSession(ctx context.Context, id string, user models.User, ttl time.Duration) error {
+	f.local.SetSession(ctx, id, user, ttl)
+
+	if f.breaker.Allow() {
+		err := f.primary.SetSession(ctx, id, user, ttl)
+		f.breaker.Record(err)
+		if err == nil {
+			return nil
+		}
+	}
+	f.mark(f.pending, id)
+	return nil
+}
+
+func (f *FallbackSessionStore) GetSession(ctx context.Context, id string) (models.User, error) {
+	if !f.breaker.Allow() {
+		return f.local.GetSession(ctx, id)
+	}
+
+	user, err := f.primary.GetSession(ctx, id)
+	f.breaker.Record(err)
+	switch {
+	case err == nil:
+		if f.take(f.revoked, id) {
+			f.primary.DeleteSession(ctx, id)
+			f.local.DeleteSession(ctx, id)
+			return models.User{}, ErrNotFound
+		}
+		f.local.SetSession(ctx, id, user, f.ttl)
+		return user, nil
+	case errors.Is(err, ErrNotFound):
+		return f.replay(ctx, id)
+	case errors.Is(err, ErrUnavailable):
+		return f.local.GetSession(ctx, id)
+	default:
+		return models.User{}, err
+	}
+}
+
+// replay pushes a session created during an outage to the primary store.
+func (f *FallbackSessionStore) replay(ctx context.Context, id string) (models.User, error) {
+	if !f.take(f.pending, id) {
+		f.local.DeleteSession(ctx, id)
+		return models.User{}, ErrNotFound
+	}
+
+	user, err := f.local.GetSession(ctx, id)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := f.primary.SetSession(ctx, id, user, f.ttl); err != nil {
+		f.mark(f.pending, id)
+	}
+	return user, nil
+}
+
+func (f *FallbackSessionStore) TouchSession(ctx context.Context, id string, ttl time.Duration) error {
+	f.local.TouchSession(ctx, id, ttl)
+
+	if !f.breaker.Allow() {
+		return nil
+	}
+	err := f.primary.TouchSession(ctx, id, ttl)
+	f.breaker.Record(err)
+	if errors.Is(err, ErrUnavailable) {
+		return nil
+	}
+	return err
+}
+
+func (f *FallbackSessionStore) DeleteSession(ctx context.Context, id string) error {
+	f.local.DeleteSession(ctx, id)
+	f.take(f.pending, id)
+
+	if f.breaker.Allow() {
+		err := f.primary.DeleteSession(ctx, id)
+		f.breaker.Record(err)
+		if err == nil {
+			return nil
+		}
+	}
+	f.mark(f.revoked, id)
+	return nil
+}
+
+func (f *FallbackSessionStore) CountSessions(ctx context.Context) (int64, error) {
+	if !f.breaker.Allow() {
+		return f.local.CountSessions(ctx)
+	}
+	n, err := f.primary.CountSessions(ctx)
+	f.breaker.Record(err)
+	if errors.Is(err, ErrUnavailable) {
+		return f.local.CountSessions(ctx)
+	}
+	return n, err
+}
+
+func (f *FallbackSessionStore) mark(set map[string]struct{}, id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	set[id] = struct{}{}
+}
+
+func (f *FallbackSessionStore) take(set map[string]struct{}, id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := set[id]
+	delete(set, id)
+	return ok
+}
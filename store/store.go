@@ -1,13 +1,31 @@
 package store
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
 
 type URLStore interface {
-	Set(ctx context.Context, key, originalURL string, userID string) error
-	GetOriginalFromKey(ctx context.Context, key string) (string, string, bool)
-	GetKeyFromOriginal(ctx context.Context, original string) (string, string, bool)
-	ContainsKey(ctx context.Context, key string) bool
-	Update(ctx context.Context, key, newValue string) bool
-	Delete(ctx context.Context, key string) bool
+	// Set inserts a new mapping and returns ErrKeyTaken if the key is in use.
+	Set(ctx context.Context, mapping models.URLMapping) error
+	// SetBatch inserts mappings with the same rules as Set. The returned
+	// slice holds one error per mapping; the second result reports a
+	// failure of the batch as a whole.
+	SetBatch(ctx context.Context, mappings []models.URLMapping) ([]error, error)
+	// Get returns the mapping for key. Links the sweeper has archived are
+	// returned too, already expired, so callers can tell them apart from
+	// keys that never existed.
+	Get(ctx context.Context, key string) (models.URLMapping, error)
+	// GetByOriginal returns owner's unexpired link to original. Each owner
+	// has at most one.
+	GetByOriginal(ctx context.Context, owner models.LinkOwner, original string) (models.URLMapping, error)
+	ContainsKey(ctx context.Context, key string) (bool, error)
+	// Update retargets key to newValue. A nil expiresAt keeps the current
+	// expiry unless clearExpiry is set, which removes it. Stores that keep
+	// edit history record the change as editedBy.
+	Update(ctx context.Context, key, newValue string, expiresAt *time.Time, clearExpiry bool, editedBy string) error
+	Delete(ctx context.Context, key string) error
 	Close() error
 }
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type ExpiredArchiver interface {
+	ArchiveExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// StartExpirySweeper periodically moves expired links out of the live
+// mappings until ctx is cancelled.
+func StartExpirySweeper(ctx context.Context, archiver ExpiredArchiver, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				n, err := archiver.ArchiveExpired(ctx, now)
+				if err != nil {
+					log.Printf("[sweeper] failed to archive expired links: %v", err)
+				} else if n > 0 {
+					log.Printf("[sweeper] archived %d expired links", n)
+				}
+			}
+		}
+	}()
+}
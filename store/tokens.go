@@ -0,0 +1,18 @@
+package store
+
+import (
+	"context"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+// TokenStore keeps API tokens by the hash of their secret; the secret
+// itself is only ever shown to the user once, when the token is created.
+type TokenStore interface {
+	CreateToken(ctx context.Context, token models.APIToken, tokenHash string) (models.APIToken, error)
+	ListTokens(ctx context.Context, userID string) ([]models.APIToken, error)
+	RevokeToken(ctx context.Context, userID, tokenID string) error
+	// AuthenticateToken resolves an active, unexpired token to its owner and
+	// records that it was used.
+	AuthenticateToken(ctx context.Context, tokenHash string) (models.User, models.APIToken, error)
+}
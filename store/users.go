@@ -6,7 +6,23 @@ import (
 	"github.com/JamieLeeNZ/url-shortener/models"
 )
 
+const (
+	SortByCreated = "created"
+	SortByClicks  = "clicks"
+	SortByKey     = "key"
+)
+
 type UserStore interface {
 	GetOrCreateUser(ctx context.Context, user models.User) (models.User, error)
-	GetURLsByUserID(ctx context.Context, id string) ([]models.URLMapping, error)
+	// GetOrCreateUserByIdentity returns the user linked to identity. Unknown
+	// identities from a trusted provider are linked to the user with the same
+	// verified email; otherwise a new user is created, or ErrEmailInUse is
+	// returned if the email already belongs to someone.
+	GetOrCreateUserByIdentity(ctx context.Context, identity models.Identity) (models.User, error)
+	// LinkIdentity attaches identity to userID, returning ErrConflict if it
+	// already belongs to someone else.
+	LinkIdentity(ctx context.Context, userID string, identity models.Identity) error
+	// GetURLsByOwner returns all of owner's links, newest first.
+	GetURLsByOwner(ctx context.Context, owner models.LinkOwner) ([]models.URLMapping, error)
+	ListURLsByUserID(ctx context.Context, id string, query models.LinkListQuery) (models.LinkListPage, error)
 }
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+
+	"github.com/JamieLeeNZ/url-shortener/models"
+)
+
+type WorkspaceStore interface {
+	// CreateWorkspace creates a workspace with ownerID as its first owner.
+	CreateWorkspace(ctx context.Context, name, ownerID string) (models.Workspace, error)
+	// ListWorkspaces returns the workspaces userID belongs to, with their
+	// role in each.
+	ListWorkspaces(ctx context.Context, userID string) ([]models.Workspace, error)
+	// GetMemberRole returns ErrNotFound if userID is not a member.
+	GetMemberRole(ctx context.Context, workspaceID, userID string) (string, error)
+	// ListMembers returns the members followed by pending invites.
+	ListMembers(ctx context.Context, workspaceID string) ([]models.WorkspaceMember, error)
+	// AddMember adds the user with the given email, returning ErrConflict if
+	// they are already a member. If nobody has signed in with the email yet
+	// it records a pending invite instead, replacing any earlier one.
+	AddMember(ctx context.Context, workspaceID, email, role string) (models.WorkspaceMember, error)
+	// AcceptInvites turns pending invites for email into memberships for
+	// userID. Callers must have verified that userID owns email.
+	AcceptInvites(ctx context.Context, userID, email string) error
+	// UpdateMemberRole returns ErrNotFound if userID is not a member and
+	// ErrConflict if it would leave the workspace without an owner.
+	UpdateMemberRole(ctx context.Context, workspaceID, userID, role string) (models.WorkspaceMember, error)
+	// RemoveMember returns ErrConflict if it would leave the workspace
+	// without an owner.
+	RemoveMember(ctx context.Context, workspaceID, userID string) error
+	// RevokeInvite returns ErrNotFound if email has no pending invite.
+	RevokeInvite(ctx context.Context, workspaceID, email string) error
+}